@@ -22,9 +22,13 @@ func getPriceHistory(Names []string, month int, ChannelID string) ([]*database.P
 			return priceList, err
 		}
 		for i := range priceArr {
+			// out of stock prices are stale or 0, leave a gap in the line instead
+			if !priceArr[i].Availability.Purchasable() {
+				continue
+			}
 			priceArr[i].Url = Name + " - " + ExtractDomainName(priceArr[i].Url)
+			priceList = append(priceList, priceArr[i])
 		}
-		priceList = append(priceList, priceArr...)
 
 	}
 	slices.SortFunc(priceList, func(a, b *database.Price) int {
@@ -101,7 +105,7 @@ func PriceHistoryChart(Names []string, month int, ChannelID string) error {
 
 	var dates []string
 	dateToIdx := make(map[string]int)
-	urlToPrices := make(map[string]map[int]int) // url -> {dateIdx -> price}
+	urlToPrices := make(map[string]map[int]float64) // url -> {dateIdx -> price}
 
 	for _, price := range priceList {
 		dateStr := price.Date.Format("Jan 02")
@@ -114,9 +118,9 @@ func PriceHistoryChart(Names []string, month int, ChannelID string) error {
 
 		// Group by URL
 		if urlToPrices[price.Url] == nil {
-			urlToPrices[price.Url] = make(map[int]int)
+			urlToPrices[price.Url] = make(map[int]float64)
 		}
-		urlToPrices[price.Url][dateToIdx[dateStr]] = price.Price
+		urlToPrices[price.Url][dateToIdx[dateStr]] = price.Price.Major()
 	}
 	result := make(map[string][]opts.LineData)
 	for url, pricesByIdx := range urlToPrices {
@@ -0,0 +1,177 @@
+package crawler
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+)
+
+const (
+	// failures older than this are deleted when a new one is saved
+	artifactMaxAge = 7 * 24 * time.Hour
+	// at most this many failures are kept, the oldest go first
+	artifactMaxFailures = 100
+	artifactTimeFormat  = "20060102-150405.000"
+)
+
+// a screenshot or page dump from a failed crawl
+type Artifact struct {
+	Name string
+	Data []byte
+}
+
+// crawl error with what the page looked like when it failed. the crawler
+// doesn't know which item it is crawling, so the artifacts are carried on
+// the error until whoever alerts on it saves them with SaveArtifacts
+type ArtifactError struct {
+	Source    string
+	Time      time.Time
+	Artifacts []Artifact
+	Err       error
+}
+
+func (e *ArtifactError) Error() string { return e.Err.Error() }
+
+func (e *ArtifactError) Unwrap() error { return e.Err }
+
+// attaches the non empty artifacts to err, a nil err stays nil
+func withArtifacts(err error, source string, artifacts ...Artifact) error {
+	if err == nil {
+		return nil
+	}
+	var kept []Artifact
+	for _, a := range artifacts {
+		if len(a.Data) != 0 {
+			kept = append(kept, a)
+		}
+	}
+	if len(kept) == 0 {
+		return err
+	}
+	return &ArtifactError{Source: source, Time: time.Now(), Artifacts: kept, Err: err}
+}
+
+// proxied and direct attempts of the same crawl end up in one folder
+func stageArtifact(proxy bool, name string, data []byte) Artifact {
+	if proxy {
+		name = "proxy-" + name
+	}
+	return Artifact{Name: name, Data: data}
+}
+
+// every ArtifactError in the tree, joined errors from second hand sources
+// and the proxy attempt wrapped around the direct one included
+func collectArtifactErrors(err error) []*ArtifactError {
+	var found []*ArtifactError
+	var walk func(error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+			return
+		case *ArtifactError:
+			found = append(found, e)
+			walk(e.Err)
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		default:
+			walk(errors.Unwrap(err))
+		}
+	}
+	walk(err)
+	return found
+}
+
+func artifactDir() string {
+	if dir := os.Getenv("DEBUG_ARTIFACT_DIR"); dir != "" {
+		return dir
+	}
+	return "debugArtifacts"
+}
+
+var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func safePathPart(s string) string {
+	s = unsafePathChars.ReplaceAllString(s, "_")
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// writes the artifacts attached to err under
+// DEBUG_ARTIFACT_DIR/item/source/timestamp and returns the file paths
+func SaveArtifacts(item string, err error) []string {
+	var paths []string
+	errs := collectArtifactErrors(err)
+	// every attempt of a crawl shares the folder of its first failure
+	started := map[string]time.Time{}
+	for _, e := range errs {
+		if t, ok := started[e.Source]; !ok || e.Time.Before(t) {
+			started[e.Source] = e.Time
+		}
+	}
+	for _, e := range errs {
+		dir := filepath.Join(artifactDir(), safePathPart(item), safePathPart(e.Source),
+			started[e.Source].Format(artifactTimeFormat))
+		if mkErr := os.MkdirAll(dir, 0o755); mkErr != nil {
+			slog.Error("could not create artifact dir", slog.String("Dir", dir), slog.Any("Error", mkErr))
+			continue
+		}
+		for _, a := range e.Artifacts {
+			path := filepath.Join(dir, safePathPart(a.Name))
+			if writeErr := os.WriteFile(path, a.Data, 0o644); writeErr != nil {
+				slog.Error("could not write artifact", slog.String("Path", path), slog.Any("Error", writeErr))
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) != 0 {
+		slog.Info("saved crawl artifacts", slog.String("Item", item), slog.Any("Paths", paths))
+		pruneArtifacts()
+	}
+	return paths
+}
+
+// enforces the age and count limits over every item and source
+func pruneArtifacts() {
+	dirs, err := filepath.Glob(filepath.Join(artifactDir(), "*", "*", "*"))
+	if err != nil {
+		slog.Error("could not list artifacts", slog.Any("Error", err))
+		return
+	}
+	type failure struct {
+		dir  string
+		time time.Time
+	}
+	var failures []failure
+	for _, dir := range dirs {
+		t, err := time.ParseInLocation(artifactTimeFormat, filepath.Base(dir), time.Local)
+		if err != nil {
+			continue
+		}
+		failures = append(failures, failure{dir, t})
+	}
+	// newest first so everything past the limit is the oldest
+	slices.SortFunc(failures, func(a, b failure) int {
+		return b.time.Compare(a.time)
+	})
+	for i, f := range failures {
+		if i < artifactMaxFailures && time.Since(f.time) < artifactMaxAge {
+			continue
+		}
+		if err := os.RemoveAll(f.dir); err != nil {
+			slog.Error("could not remove old artifacts", slog.String("Dir", f.dir), slog.Any("Error", err))
+			continue
+		}
+		// drop the source and item folders once they are empty
+		os.Remove(filepath.Dir(f.dir))
+		os.Remove(filepath.Dir(filepath.Dir(f.dir)))
+	}
+}
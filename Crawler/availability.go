@@ -0,0 +1,148 @@
+package crawler
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type Availability string
+
+const (
+	AvailabilityUnknown        Availability = ""
+	AvailabilityInStock        Availability = "InStock"
+	AvailabilityOutOfStock     Availability = "OutOfStock"
+	AvailabilityPreOrder       Availability = "PreOrder"
+	AvailabilityThirdPartyOnly Availability = "ThirdPartyOnly"
+)
+
+// unknown is treated as purchasable since most pages don't say either way
+func (a Availability) Purchasable() bool {
+	return a != AvailabilityOutOfStock && a != AvailabilityThirdPartyOnly
+}
+
+// the page said it can be bought, unknown only means the stock markup
+// was missing or failed to parse
+func (a Availability) Confirmed() bool {
+	return a == AvailabilityInStock || a == AvailabilityPreOrder
+}
+
+func (a Availability) Label() string {
+	switch a {
+	case AvailabilityInStock:
+		return "In Stock"
+	case AvailabilityOutOfStock:
+		return "Out Of Stock"
+	case AvailabilityPreOrder:
+		return "Pre-Order"
+	case AvailabilityThirdPartyOnly:
+		return "Third Party Sellers Only"
+	}
+	return "Unknown"
+}
+
+// maps schema.org ItemAvailability values and common meta tag values
+func parseAvailability(value string) Availability {
+	value = strings.ToLower(strings.TrimSpace(value))
+	value = value[strings.LastIndex(value, "/")+1:]
+	value = strings.NewReplacer(" ", "", "_", "", "-", "").Replace(value)
+	switch value {
+	case "instock", "limitedavailability", "onlineonly", "instoreonly", "available":
+		return AvailabilityInStock
+	case "outofstock", "soldout", "discontinued", "oos", "unavailable":
+		return AvailabilityOutOfStock
+	case "preorder", "presale", "backorder":
+		return AvailabilityPreOrder
+	}
+	return AvailabilityUnknown
+}
+
+// checks structured data first, then the site profile selectors, and only
+// falls back to reading the page text when no price was found since stock
+// phrases also show up in recommendation carousels
+func extractAvailability(doc *goquery.Selection, profile SiteProfile, priceFound bool) Availability {
+	var availability Availability
+	doc.Find("script[type='application/ld+json']").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		var data any
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			return true
+		}
+		availability = parseAvailability(findJSONLDAvailability(data))
+		return availability == AvailabilityUnknown
+	})
+	if availability != AvailabilityUnknown {
+		return availability
+	}
+
+	if el := doc.Find("[itemprop='availability']").First(); el.Length() != 0 {
+		value, ok := el.Attr("href")
+		if !ok {
+			value = el.AttrOr("content", el.Text())
+		}
+		if availability = parseAvailability(value); availability != AvailabilityUnknown {
+			return availability
+		}
+	}
+	for _, selector := range []string{
+		"meta[property='og:availability']",
+		"meta[property='product:availability']",
+	} {
+		if value, ok := doc.Find(selector).First().Attr("content"); ok {
+			if availability = parseAvailability(value); availability != AvailabilityUnknown {
+				return availability
+			}
+		}
+	}
+
+	for _, check := range []struct {
+		selectors    []string
+		availability Availability
+	}{
+		{profile.OutOfStockSelectors, AvailabilityOutOfStock},
+		{profile.ThirdPartyOnlySelectors, AvailabilityThirdPartyOnly},
+		{profile.PreOrderSelectors, AvailabilityPreOrder},
+	} {
+		for _, selector := range check.selectors {
+			if doc.Find(selector).Length() != 0 {
+				return check.availability
+			}
+		}
+	}
+
+	if priceFound {
+		return AvailabilityUnknown
+	}
+	text := strings.ToLower(doc.Find("body").Text())
+	switch {
+	case strings.Contains(text, "currently unavailable"),
+		strings.Contains(text, "out of stock"),
+		strings.Contains(text, "sold out"):
+		return AvailabilityOutOfStock
+	case strings.Contains(text, "pre-order"), strings.Contains(text, "preorder"):
+		return AvailabilityPreOrder
+	}
+	return AvailabilityUnknown
+}
+
+// same walk as findJSONLDPrice but for the offer availability
+func findJSONLDAvailability(data any) string {
+	switch v := data.(type) {
+	case []any:
+		for _, child := range v {
+			if availability := findJSONLDAvailability(child); availability != "" {
+				return availability
+			}
+		}
+	case map[string]any:
+		if availability, ok := v["availability"].(string); ok {
+			return availability
+		}
+		for _, key := range []string{"offers", "@graph", "mainEntity", "hasVariant"} {
+			if availability := findJSONLDAvailability(v[key]); availability != "" {
+				return availability
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,235 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// tabs open at once across every browser when BROWSER_MAX_TABS is unset
+	defaultBrowserTabs = 2
+	// browsers without a leased tab for this long are shut down
+	browserIdleTimeout = 10 * time.Minute
+	// how long a lease waits for a free tab slot before giving up
+	tabSlotWait = 5 * time.Minute
+)
+
+// one chrome process, proxies are set per process so every proxy server
+// gets its own browser and "" is the direct one
+type browser struct {
+	proxyServer string
+	allocCancel context.CancelFunc
+	ctx         context.Context
+	cancel      context.CancelFunc
+	tabs        int
+	lastUsed    time.Time
+}
+
+var (
+	browsers   = map[string]*browser{}
+	browsersMu sync.Mutex
+	// global tab limit, a slot is held for as long as a tab is leased
+	tabSlots     chan struct{}
+	browsersOnce sync.Once
+)
+
+func initBrowserPool() {
+	limit := defaultBrowserTabs
+	if n, err := strconv.Atoi(os.Getenv("BROWSER_MAX_TABS")); err == nil && n > 0 {
+		limit = n
+	}
+	tabSlots = make(chan struct{}, limit)
+	go reapIdleBrowsers()
+	slog.Info("browser pool started", slog.Int("MaxTabs", limit))
+}
+
+func startBrowser(proxyServer string) (*browser, error) {
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.UserAgent("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"),
+		chromedp.Flag("disable-blink-features", "AutomationControlled"),
+		chromedp.Flag("no-sandbox", true),
+		chromedp.Flag("disable-dev-shm-usage", true),
+		chromedp.Flag("log-level", "3"),
+	)
+	if proxyServer != "" {
+		opts = append(opts, chromedp.ProxyServer(proxyServer))
+	}
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	ctx, cancel := chromedp.NewContext(allocCtx)
+	// running with no actions launches chrome so a broken install fails
+	// here instead of in the middle of a crawl
+	if err := chromedp.Run(ctx); err != nil {
+		cancel()
+		allocCancel()
+		return nil, fmt.Errorf("could not start browser: %w", err)
+	}
+	slog.Info("browser started", slog.String("Proxy", proxyServer))
+	return &browser{
+		proxyServer: proxyServer,
+		allocCancel: allocCancel,
+		ctx:         ctx,
+		cancel:      cancel,
+		lastUsed:    time.Now(),
+	}, nil
+}
+
+// chrome crashed or was closed, tabs can't be opened in it anymore
+func (b *browser) dead() bool {
+	if b.ctx.Err() != nil {
+		return true
+	}
+	c := chromedp.FromContext(b.ctx)
+	if c == nil || c.Browser == nil {
+		return true
+	}
+	select {
+	case <-c.Browser.LostConnection:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *browser) close() {
+	b.cancel()
+	b.allocCancel()
+}
+
+// returns the running browser for the proxy server, restarting it if it
+// crashed. tabs still leased in a crashed browser fail on their own. chrome
+// is launched without holding the lock so leases for other proxies and
+// releases don't wait on a slow start
+func getBrowser(proxyServer string) (*browser, error) {
+	browsersMu.Lock()
+	if b, ok := browsers[proxyServer]; ok {
+		if !b.dead() {
+			browsersMu.Unlock()
+			return b, nil
+		}
+		slog.Warn("browser crashed, restarting", slog.String("Proxy", proxyServer))
+		b.close()
+		delete(browsers, proxyServer)
+	}
+	browsersMu.Unlock()
+
+	started, err := startBrowser(proxyServer)
+	if err != nil {
+		return nil, err
+	}
+	browsersMu.Lock()
+	defer browsersMu.Unlock()
+	// another lease may have started one for the same proxy meanwhile,
+	// only one of them is kept
+	if b, ok := browsers[proxyServer]; ok && !b.dead() {
+		started.close()
+		return b, nil
+	}
+	browsers[proxyServer] = started
+	return started, nil
+}
+
+// opens a tab in the shared browser for the proxy server, "" for no proxy.
+// waits up to tabSlotWait while every tab slot is in use, cancel closes the
+// tab and frees the slot. the tab is also closed when parent is done, so
+// shutdown stops crawls that are waiting on a slot or still running
+func leaseTab(parent context.Context, timeout time.Duration, proxyServer string) (context.Context, context.CancelFunc, error) {
+	browsersOnce.Do(initBrowserPool)
+	if err := parent.Err(); err != nil {
+		return nil, nil, err
+	}
+	wait := time.NewTimer(tabSlotWait)
+	defer wait.Stop()
+	select {
+	case tabSlots <- struct{}{}:
+	case <-wait.C:
+		return nil, nil, fmt.Errorf("no free browser tab after %s", tabSlotWait)
+	case <-parent.Done():
+		return nil, nil, parent.Err()
+	}
+
+	var b *browser
+	var tabCtx context.Context
+	var tabCancel context.CancelFunc
+	var err error
+	// a browser can die between the health check and the new tab, so
+	// the tab is retried once in a fresh browser
+	for range 2 {
+		b, err = getBrowser(proxyServer)
+		if err != nil {
+			continue
+		}
+		tabCtx, tabCancel = chromedp.NewContext(b.ctx)
+		if err = chromedp.Run(tabCtx); err == nil {
+			break
+		}
+		tabCancel()
+		slog.Warn("could not open tab", slog.String("Proxy", proxyServer), slog.Any("Error", err))
+		browsersMu.Lock()
+		if browsers[proxyServer] == b {
+			b.close()
+			delete(browsers, proxyServer)
+		}
+		browsersMu.Unlock()
+	}
+	if err != nil {
+		<-tabSlots
+		return nil, nil, err
+	}
+
+	browsersMu.Lock()
+	b.tabs++
+	browsersMu.Unlock()
+	ctx, timeoutCancel := context.WithTimeout(tabCtx, timeout)
+	// the tab lives in the browser context, not parent, so parent being
+	// done is forwarded to it
+	stopParent := context.AfterFunc(parent, timeoutCancel)
+	// safe to call more than once, crawls free the tab early before
+	// retrying without a proxy so the retry can get a slot
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			stopParent()
+			timeoutCancel()
+			tabCancel()
+			browsersMu.Lock()
+			b.tabs--
+			b.lastUsed = time.Now()
+			browsersMu.Unlock()
+			<-tabSlots
+		})
+	}
+	return ctx, cancel, nil
+}
+
+func reapIdleBrowsers() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		browsersMu.Lock()
+		for proxyServer, b := range browsers {
+			if b.tabs == 0 && (b.dead() || time.Since(b.lastUsed) > browserIdleTimeout) {
+				slog.Info("closing idle browser", slog.String("Proxy", proxyServer))
+				b.close()
+				delete(browsers, proxyServer)
+			}
+		}
+		browsersMu.Unlock()
+	}
+}
+
+// closes every browser, called on shutdown so no chrome processes are left
+func CloseBrowsers() {
+	browsersMu.Lock()
+	defer browsersMu.Unlock()
+	for proxyServer, b := range browsers {
+		b.close()
+		delete(browsers, proxyServer)
+	}
+}
@@ -0,0 +1,29 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeaseTabCanceledWhileWaiting(t *testing.T) {
+	browsersOnce.Do(initBrowserPool)
+	// every slot is taken so the lease has to wait for one
+	for range cap(tabSlots) {
+		tabSlots <- struct{}{}
+	}
+	t.Cleanup(func() {
+		for range cap(tabSlots) {
+			<-tabSlots
+		}
+	})
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, _, err := leaseTab(ctx, time.Minute, ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if _, _, err := leaseTab(ctx, time.Minute, ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v for a lease after shutdown, want context.Canceled", err)
+	}
+}
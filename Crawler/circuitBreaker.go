@@ -0,0 +1,168 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// failed crawls in a row before a domain is skipped
+	breakerThreshold = 3
+	// cooldown doubles every time the trial crawl after it fails
+	breakerCooldown    = 30 * time.Minute
+	breakerMaxCooldown = 4 * time.Hour
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed BreakerState = "closed"
+	BreakerOpen   BreakerState = "open"
+	// cooldown is over and one trial crawl is allowed through
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// returned instead of crawling while the breaker of a domain is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// crawlers wrap failures where the site refused or couldn't be reached
+// with ErrBlocked, a failed request or navigation, an error status or a
+// page without the results on it. only these count against the breaker,
+// a search that found nothing or a price that didn't parse means the site
+// still answers
+var ErrBlocked = errors.New("site blocked or unreachable")
+
+func blocked(err error) error {
+	if err == nil || errors.Is(err, ErrBlocked) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrBlocked, err)
+}
+
+type BreakerStatus struct {
+	Domain    string
+	State     BreakerState
+	Failures  int
+	OpenUntil time.Time
+	LastError string
+}
+
+type breaker struct {
+	status   BreakerStatus
+	cooldown time.Duration
+	// a trial crawl is running, everything else is still rejected
+	trial bool
+}
+
+var (
+	breakers   = map[string]*breaker{}
+	breakersMu sync.Mutex
+	// called when a domain trips and when it recovers, set by the scheduler
+	// to send a single alert instead of one per item
+	OnBreakerChange func(status BreakerStatus)
+)
+
+// domain the breaker of a url is kept under
+func BreakerDomain(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" {
+		return uri
+	}
+	return proxyDomain(u.Hostname())
+}
+
+// errors with ErrCircuitOpen when the domain should not be crawled
+func breakerAllow(domain string) error {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[domain]
+	if !ok || b.status.State == BreakerClosed {
+		return nil
+	}
+	if b.status.State == BreakerOpen && time.Now().After(b.status.OpenUntil) {
+		b.status.State = BreakerHalfOpen
+	}
+	if b.status.State == BreakerHalfOpen && !b.trial {
+		b.trial = true
+		slog.Info("circuit breaker trial crawl", slog.String("Domain", domain))
+		return nil
+	}
+	return fmt.Errorf("%w for %s until %s after %d failures, last error: %s", ErrCircuitOpen,
+		domain, b.status.OpenUntil.Format(time.Kitchen), b.status.Failures, b.status.LastError)
+}
+
+func breakerRecord(domain string, err error) {
+	if !errors.Is(err, ErrBlocked) {
+		err = nil
+	}
+	breakersMu.Lock()
+	b, ok := breakers[domain]
+	if !ok {
+		b = &breaker{status: BreakerStatus{Domain: domain, State: BreakerClosed}, cooldown: breakerCooldown}
+		breakers[domain] = b
+	}
+	wasTrial := b.trial
+	b.trial = false
+	var changed *BreakerStatus
+	if err == nil {
+		if b.status.State != BreakerClosed {
+			slog.Info("circuit breaker closed", slog.String("Domain", domain))
+			b.status.State = BreakerClosed
+			changed = &b.status
+		}
+		b.status.Failures = 0
+		b.status.LastError = ""
+		b.cooldown = breakerCooldown
+	} else {
+		b.status.Failures++
+		b.status.LastError = err.Error()
+		switch {
+		case wasTrial:
+			b.cooldown = min(b.cooldown*2, breakerMaxCooldown)
+			b.status.State = BreakerOpen
+			b.status.OpenUntil = time.Now().Add(b.cooldown)
+		case b.status.State == BreakerClosed && b.status.Failures >= breakerThreshold:
+			b.status.State = BreakerOpen
+			b.status.OpenUntil = time.Now().Add(b.cooldown)
+			slog.Warn("circuit breaker tripped", slog.String("Domain", domain),
+				slog.Int("Failures", b.status.Failures), slog.Time("OpenUntil", b.status.OpenUntil),
+				slog.Any("Error", err))
+			changed = &b.status
+		}
+	}
+	var status BreakerStatus
+	if changed != nil {
+		status = *changed
+	}
+	breakersMu.Unlock()
+	// hook is called without the lock since it sends discord messages
+	if changed != nil && OnBreakerChange != nil {
+		OnBreakerChange(status)
+	}
+}
+
+// state of every domain that has been crawled, open breakers first
+func BreakerStatuses() []BreakerStatus {
+	breakersMu.Lock()
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, b := range breakers {
+		statuses = append(statuses, b.status)
+	}
+	breakersMu.Unlock()
+	slices.SortFunc(statuses, func(a, b BreakerStatus) int {
+		if (a.State == BreakerClosed) != (b.State == BreakerClosed) {
+			if a.State == BreakerClosed {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+	return statuses
+}
@@ -0,0 +1,36 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBreakerRecord(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		err      error
+		wantOpen bool
+	}{
+		{"blocked", blocked(errors.New("Forbidden")), true},
+		{"wrapped", withArtifacts(errors.Join(errors.New("Error in mercari:"), blocked(errors.New("no items"))), "mercari"), true},
+		{"sentinel", fmt.Errorf("auctions: %w", errNoAuctionResults), true},
+		// the site answered, the price or the results just weren't there
+		{"not blocked", errors.New("failed to parse price"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := "breaker-" + tt.name + ".test"
+			t.Cleanup(func() {
+				breakersMu.Lock()
+				delete(breakers, domain)
+				breakersMu.Unlock()
+			})
+			for range breakerThreshold {
+				breakerRecord(domain, tt.err)
+			}
+			if err := breakerAllow(domain); (err != nil) != tt.wantOpen || (err != nil && !errors.Is(err, ErrCircuitOpen)) {
+				t.Errorf("got %v, want open %v", err, tt.wantOpen)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	types "priceTracker/Types"
+
+	"github.com/gocolly/colly/v2"
+)
+
+type craigslistSource struct{}
+
+func init() {
+	RegisterSecondHandSource(craigslistSource{})
+}
+
+func (craigslistSource) Name() string { return "craigslist" }
+
+func (craigslistSource) Domain() string { return "craigslist.org" }
+
+func (craigslistSource) ItemTypes() []string { return nil }
+
+func (craigslistSource) Search(ctx context.Context, query SecondHandQuery) ([]*types.EbayListing, error) {
+	return CrawlCraigslist(query.Name, query.Price, query.titleRules("craigslist"),
+		query.Lat, query.Long, query.Distance, true)
+}
+
+// the site only decides which area is shown first, the lat and long
+// radius search reaches into the neighbouring areas
+func craigslistSite() string {
+	if site := os.Getenv("CRAIGSLIST_SITE"); site != "" {
+		return site
+	}
+	return "losangeles"
+}
+
+// all for sale posts near the channel location, newest first
+func CraigslistURLGenerator(Name string, Price types.Money, homeLat, homeLong float64, maxDistance int) string {
+	baseURL := fmt.Sprintf("https://%s.craigslist.org/search/sss?query=", craigslistSite())
+	priceQuery := fmt.Sprintf("&max_price=%d&sort=date&bundleDuplicates=1", int(Price.Major()))
+	location := ""
+	if maxDistance > 0 {
+		location = fmt.Sprintf("&lat=%.4f&lon=%.4f&search_distance=%d", homeLat, homeLong, maxDistance)
+	}
+	return baseURL + url.QueryEscape(Name) + priceQuery + location
+}
+
+// craigslist does the distance check itself with a straight line radius,
+// so unlike facebook there is no routing call per listing
+func CrawlCraigslist(Name string, desiredPrice types.Money, rules *TitleRules, homeLat, homeLong float64,
+	maxDistance int, Proxy bool,
+) ([]*types.EbayListing, error) {
+	uri := CraigslistURLGenerator(Name, desiredPrice, homeLat, homeLong, maxDistance)
+	Proxy = useProxy(Proxy)
+	slog.Info("crawling craigslist URL", slog.String("URL", uri), slog.Bool("proxy", Proxy))
+	crawlDate := time.Now()
+	var retArr []*types.EbayListing
+	visited := false
+	c := initCrawler(Proxy)
+	// the page is rendered with js, the static list is there for crawlers
+	c.OnHTML("ol.cl-static-search-results", func(e *colly.HTMLElement) {
+		visited = true
+	})
+	c.OnHTML("li.cl-static-search-result", func(e *colly.HTMLElement) {
+		title := e.ChildText("div.title")
+		if title == "" {
+			title = e.Attr("title")
+		}
+		link := e.ChildAttr("a", "href")
+		price, err := formatPrice(e.ChildText("div.price"))
+		if price.IsZero() || err != nil {
+			// free stuff and posts without a price
+			slog.Debug("skipping craigslist post without price", slog.String("URL", link))
+			return
+		}
+		listing := &types.EbayListing{
+			ItemName:      Name,
+			Title:         title,
+			Price:         price,
+			Condition:     strings.TrimSpace(e.ChildText("div.location")),
+			URL:           link,
+			Date:          crawlDate,
+			Duration:      0,
+			AcceptsOffers: true,
+		}
+		if !rules.keep(listing) {
+			return
+		}
+		if overLimit(desiredPrice, price) {
+			rules.reject(listing, types.RejectPrice, desiredPrice.String())
+			return
+		}
+		slog.Info("listing", slog.Any("craigslist listing information", listing))
+		retArr = append(retArr, listing)
+	})
+	err := c.Visit(uri)
+	c.Wait()
+	if err != nil || !visited {
+		if Proxy {
+			slog.Warn("craigslist proxy failed, redoing request without proxy", slog.Any("Error", err))
+			return CrawlCraigslist(Name, desiredPrice, rules, homeLat, homeLong, maxDistance, false)
+		}
+		if err == nil {
+			err = errors.New("Craigslist results not found, might have been blocked")
+		}
+		return retArr, blocked(err)
+	}
+	return retArr, nil
+}
@@ -0,0 +1,69 @@
+package crawler
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+func TestCraigslistURLGenerator(t *testing.T) {
+	t.Setenv("CRAIGSLIST_SITE", "orangecounty")
+	uri := CraigslistURLGenerator("steam deck", usd(40000), 33.8358, -118.3406, 25)
+	want := "https://orangecounty.craigslist.org/search/sss?query=steam+deck&max_price=400" +
+		"&sort=date&bundleDuplicates=1&lat=33.8358&lon=-118.3406&search_distance=25"
+	if uri != want {
+		t.Errorf("got %s, want %s", uri, want)
+	}
+	// without a distance the whole area is searched
+	if uri := CraigslistURLGenerator("steam deck", usd(40000), 33.8358, -118.3406, 0); strings.Contains(uri, "lat=") {
+		t.Errorf("got location in %s", uri)
+	}
+}
+
+func TestCrawlCraigslist(t *testing.T) {
+	replayFixtures(t)
+	price := usd(40000)
+	recordPage(t, CraigslistURLGenerator("steam deck", price, 33.8358, -118.3406, 25), "craigslistSearch.html")
+	rules, rejected := testRules("steam deck", "Tech", types.TitleFilter{})
+
+	listings, err := CrawlCraigslist("steam deck", price, rules, 33.8358, -118.3406, 25, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://losangeles.craigslist.org/sgv/vgm/d/torrance-steam-deck-512gb-oled/7712345601.html",
+		"https://losangeles.craigslist.org/lgb/vgm/d/long-beach-valve-steam-deck-64gb/7712345602.html",
+	}
+	if got := listingURLs(listings); !slices.Equal(got, want) {
+		t.Fatalf("got listings %v, want %v", got, want)
+	}
+	if listings[0].Price != usd(35000) || listings[0].Condition != "Torrance" {
+		t.Errorf("got price %s location %q", listings[0].Price, listings[0].Condition)
+	}
+	// cards without a title div fall back to the title attribute
+	if listings[1].Title != "Valve steam deck 64gb with case" {
+		t.Errorf("got title %q", listings[1].Title)
+	}
+
+	// the free post is skipped without a rejection
+	var reasons []types.RejectReason
+	for _, r := range *rejected {
+		reasons = append(reasons, r.Reason)
+	}
+	if !slices.Equal(reasons, []types.RejectReason{types.RejectExcluded, types.RejectPrice}) {
+		t.Errorf("got rejections %v", reasons)
+	}
+}
+
+func TestCrawlCraigslistBlocked(t *testing.T) {
+	replayFixtures(t)
+	price := usd(40000)
+	recordPage(t, CraigslistURLGenerator("steam deck", price, 0, 0, 0), "blocked.html")
+	rules, _ := testRules("steam deck", "Tech", types.TitleFilter{})
+
+	if _, err := CrawlCraigslist("steam deck", price, rules, 0, 0, 0, true); err == nil {
+		t.Fatal("expected an error for a page without the results list")
+	}
+}
@@ -2,43 +2,46 @@ package crawler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"math/rand/v2"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	types "priceTracker/Types"
+
+	"github.com/PuerkitoBio/goquery"
 	"github.com/chromedp/chromedp"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/extensions"
 )
 
-var TaxRate = 1.1
-
-func initCrawler() *colly.Collector {
+// proxied collectors go through the proxy pool, see attachProxyPool
+func initCrawler(proxy bool) *colly.Collector {
 	// --------------------------- initiaize scrapper headers and settings ------- //
 	var c *colly.Collector
 	c = colly.NewCollector(
 		colly.MaxDepth(1),
 		colly.AllowURLRevisit(),
 	)
-	c.Limit(&colly.LimitRule{
-		DomainGlob:  "*ebay.*",
-		Delay:       1 * time.Minute,
-		RandomDelay: 3 * time.Minute,
-	})
-
 	c.SetRequestTimeout(30 * time.Second)
-	c.Limit(&colly.LimitRule{
-		DomainGlob:  "*",
-		Parallelism: 2,
-		Delay:       2 * time.Second,
-		RandomDelay: 1 * time.Second,
-	})
+	// replayed fixtures come from disk, there is no site to be polite to
+	if fixtureMode() != fixtureReplay {
+		c.Limit(&colly.LimitRule{
+			DomainGlob:  "*ebay.*",
+			Delay:       1 * time.Minute,
+			RandomDelay: 3 * time.Minute,
+		})
+		c.Limit(&colly.LimitRule{
+			DomainGlob:  "*",
+			Parallelism: 2,
+			Delay:       2 * time.Second,
+			RandomDelay: 1 * time.Second,
+		})
+	}
 	extensions.RandomUserAgent(c)
 	c.OnRequest(func(r *colly.Request) {
 		r.Headers.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8")
@@ -52,10 +55,16 @@ func initCrawler() *colly.Collector {
 		r.Headers.Set("Referer", "https://www.google.com/")
 		r.Headers.Set("Accept-Encoding", "gzip, deflate")
 	})
-	c.WithTransport(&http.Transport{
+	transport := &http.Transport{
 		DisableCompression: false,
-	})
-	c.SetProxy("http://gluetun:8888")
+	}
+	c.WithTransport(transport)
+	if proxy {
+		// sets the proxy on transport, it has to be in place before the
+		// fixture wrapper since colly only sets proxies on an http.Transport
+		attachProxyPool(c)
+	}
+	c.WithTransport(withFixtures(transport))
 	c.OnError(func(r *colly.Response, err error) {
 		s := fmt.Sprintf("Error scraping %s: %v", r.Request.URL, err)
 		slog.Error(s)
@@ -63,70 +72,109 @@ func initCrawler() *colly.Collector {
 	return c
 }
 
-func GetPrice(uri string, querySelector string, proxy bool) (int, error) {
+// result of a tracker crawl, strategy records how the price was found.
+// the price is as shown on the page, tax and shipping are added by the
+// caller since they depend on the channel
+type PriceResult struct {
+	Price        types.Money
+	Strategy     string
+	Availability Availability
+}
+
+func GetPrice(ctx context.Context, uri string, querySelector string, proxy bool) (types.Money, error) {
+	res, err := CrawlPrice(ctx, uri, querySelector, proxy)
+	return res.Price, err
+}
+
+// querySelector can be AutoSelector to read the price from structured data,
+// otherwise the site profile selectors are used as fallbacks. the crawl is
+// skipped while the breaker of the domain is open, and only counts as
+// failed once the whole proxy, no proxy, chromedp ladder failed
+func CrawlPrice(ctx context.Context, uri string, querySelector string, proxy bool) (PriceResult, error) {
+	domain := BreakerDomain(uri)
+	if err := breakerAllow(domain); err != nil {
+		slog.Warn("skipping crawl", slog.String("URI", uri), slog.Any("Error", err))
+		return PriceResult{}, err
+	}
+	res, err := crawlPrice(ctx, uri, querySelector, proxy)
+	breakerRecord(domain, err)
+	return res, err
+}
+
+func crawlPrice(ctx context.Context, uri string, querySelector string, proxy bool) (PriceResult, error) {
 	var err, priceErr error
-	res := 0
+	res := PriceResult{}
 	crawled := false
+	proxy = useProxy(proxy)
 	slog.Info("logging url", slog.String("URI", uri), slog.Bool("proxy", proxy))
-	c := initCrawler()
-	if !proxy {
-		c.SetProxyFunc(nil)
+	profile, _ := ProfileForURL(uri)
+	if profile.RequiresChromedp {
+		slog.Info("site profile requires chromedp, skipping colly", slog.String("Profile", profile.Name))
+		return ChromeDPFailover(ctx, uri, querySelector, proxy)
 	}
-	c.OnHTML(querySelector, func(h *colly.HTMLElement) {
-		crawled = true
-		res, priceErr = formatPrice(h.Text)
-		c.OnHTMLDetach(querySelector)
-	})
+	c := initCrawler(proxy)
+	selectors := priceSelectorsFor(uri, querySelector)
+	currency := CurrencyForURL(uri)
 	var collyHTML string
-	c.OnHTML("body", func(h *colly.HTMLElement) {
-		collyHTML, _ = h.DOM.Html()
+	c.OnHTML("html", func(h *colly.HTMLElement) {
+		crawled = true
+		collyHTML, _ = h.DOM.Find("body").Html()
+		if querySelector == AutoSelector {
+			res.Price, res.Strategy, priceErr = extractStructuredPrice(h.DOM, currency)
+		} else {
+			res.Price, priceErr = extractSelectorPrice(h.DOM, selectors, currency)
+			res.Strategy = StrategyCSS
+		}
+		res.Availability = extractAvailability(h.DOM, profile, priceErr == nil)
+		// an out of stock page without a price is not a crawl failure
+		if priceErr != nil && !res.Availability.Purchasable() {
+			slog.Info("price not found, item unavailable",
+				slog.String("URI", uri), slog.String("Availability", res.Availability.Label()))
+			priceErr = nil
+		}
 	})
 	err = c.Visit(uri)
 
 	c.Wait()
-	if !crawled {
+	if !crawled && err == nil {
 		err = errors.New("could not crawl, html element does not exist")
 	}
 	if err != nil || priceErr != nil {
-		var res int
 		var err2 error
-		os.WriteFile("collyHTML.html", []byte(collyHTML), 0o644)
+		collyPage := stageArtifact(proxy, "colly.html", []byte(collyHTML))
 		if proxy {
 			slog.Warn("error in getting price in crawler, triggering no proxy crawl",
 				slog.Any("Error", err), slog.Any("PriceErr", priceErr))
-			return GetPrice(uri, querySelector, false)
+			res, err2 = crawlPrice(ctx, uri, querySelector, false)
+			return res, withArtifacts(err2, "tracker", collyPage)
 		} else {
 			slog.Warn("no proxy also failed, triggering chromeDPFailover crawl",
-				slog.Any("Error", err2), slog.Int("Price", res))
-			res, err2 = ChromeDPFailover(uri, querySelector, true)
-			return int(float64(res) * TaxRate), err2
+				slog.Any("Error", err), slog.Any("PriceErr", priceErr))
+			res, err2 = ChromeDPFailover(ctx, uri, querySelector, true)
+			return res, withArtifacts(err2, "tracker", collyPage)
 		}
 	}
-	return int(float64(res) * TaxRate), err
+	return res, err
 }
 
-func NewChromedpContext(timeout time.Duration, extraOpts ...chromedp.ExecAllocatorOption) (context.Context, context.CancelFunc) {
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.UserAgent("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"),
-		chromedp.Flag("disable-blink-features", "AutomationControlled"),
-		chromedp.Flag("no-sandbox", true),
-		chromedp.Flag("disable-dev-shm-usage", true),
-		chromedp.Flag("log-level", "3"),
-	)
-
-	opts = append(opts, extraOpts...)
-
-	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	ctx, ctxCancel := chromedp.NewContext(allocCtx)
-	ctx, timeoutCancel := context.WithTimeout(ctx, timeout)
-
-	cancel := func() {
-		timeoutCancel()
-		ctxCancel()
-		allocCancel()
+// uses the first selector found on the page whose text parses to a price
+func extractSelectorPrice(doc *goquery.Selection, selectors []string, currency string) (types.Money, error) {
+	var errs []error
+	for _, selector := range selectors {
+		el := doc.Find(selector).First()
+		if el.Length() == 0 {
+			continue
+		}
+		price, err := parsePrice(el.Text(), currency)
+		if err == nil && !price.IsZero() {
+			return price, nil
+		}
+		errs = append(errs, fmt.Errorf("could not parse price for selector %s: %w", selector, err))
 	}
-
-	return ctx, cancel
+	if len(errs) == 0 {
+		return types.Money{}, errors.New("could not crawl, html element does not exist")
+	}
+	return types.Money{}, errors.Join(errs...)
 }
 
 func StealthActions() chromedp.Action {
@@ -173,89 +221,146 @@ func StealthActions() chromedp.Action {
 	`, nil)
 }
 
-func ChromeDPFailover(url string, selector string, proxy bool) (int, error) {
+func ChromeDPFailover(ctx context.Context, url string, selector string, proxy bool) (PriceResult, error) {
 	slog.Warn("ChromDP Triggered for default crawler",
 		slog.String("URL", url), slog.String("Selector", selector),
 		slog.Bool("Proxy", proxy),
 	)
-	var ctx context.Context
-	var cancel context.CancelFunc
-	if proxy {
-		ctx, cancel = NewChromedpContext(90*time.Second, chromedp.ProxyServer("http://gluetun:8888"))
-	} else {
-		ctx, cancel = NewChromedpContext(90 * time.Second)
-	}
-	defer cancel()
-
 	var priceText string
 	var screenShot []byte
 	var HTMLContent string
+	var pageHTML string
 	var err error
-	js := fmt.Sprintf(`document.querySelector("%s")?.innerText || ""`, selector)
-	if strings.Contains(url, "amazon") {
-		err = chromedp.Run(ctx,
-			chromedp.Navigate(url),
-			StealthActions(),
-			chromedp.Sleep(time.Duration(rand.IntN(10)+15)*time.Second),
-			chromedp.FullScreenshot(&screenShot, 70),
-			chromedp.OuterHTML("body", &HTMLContent),
-			chromedp.Evaluate(`document.querySelector('button.a-button-text[alt="Continue shopping"]')?.click()`, nil),
-			chromedp.Sleep(5*time.Second),
-			// chromedp.Text(selector, &priceText, chromedp.ByQuery),
-			chromedp.Evaluate(js, &priceText),
-		)
-	} else {
-		err = chromedp.Run(ctx,
+	// selectors are tried in order in the browser, innerText is used instead
+	// of parsing the html since it skips visually hidden elements
+	selectors, _ := json.Marshal(priceSelectorsFor(url, selector))
+	js := fmt.Sprintf(`(() => {
+		for (const s of %s) {
+			const text = document.querySelector(s)?.innerText;
+			if (text) return text;
+		}
+		return "";
+	})()`, selectors)
+	if selector == AutoSelector {
+		// structured data is parsed from the full document in go
+		js = `document.documentElement.outerHTML`
+	}
+	profile, _ := ProfileForURL(url)
+	var pooled *Proxy
+	var leaseErr error
+	err = func() error {
+		var tabCtx context.Context
+		var cancel context.CancelFunc
+		tabCtx, cancel, pooled, leaseErr = leaseProxyTab(ctx, url, proxy, 90*time.Second)
+		if leaseErr != nil {
+			return leaseErr
+		}
+		defer cancel()
+		load := chromedp.Tasks{
 			chromedp.Navigate(url),
 			StealthActions(),
-			chromedp.Sleep(time.Duration(rand.IntN(10)+30)*time.Second),
+			chromedp.Sleep(time.Duration(rand.IntN(10)+30) * time.Second),
 			chromedp.FullScreenshot(&screenShot, 70),
 			chromedp.OuterHTML("body", &HTMLContent),
+		}
+		if len(profile.PreClickSelectors) != 0 {
+			load = chromedp.Tasks{
+				chromedp.Navigate(url),
+				StealthActions(),
+				chromedp.Sleep(time.Duration(rand.IntN(10)+15) * time.Second),
+				chromedp.FullScreenshot(&screenShot, 70),
+				chromedp.OuterHTML("body", &HTMLContent),
+				preClickActions(profile),
+				chromedp.Sleep(5 * time.Second),
+			}
+		}
+		// the page doesn't depend on the selector, so one snapshot of it
+		// replays every selector
+		err := chromedpSnapshot(tabCtx, "price", url, load, chromedp.Tasks{
 			chromedp.Evaluate(js, &priceText),
-		)
+			chromedp.OuterHTML("html", &pageHTML),
+		})
+		pooled.Report(err)
+		return err
+	}()
+	if leaseErr != nil {
+		return PriceResult{}, leaseErr
+	}
+	proxy = pooled != nil
+	availability := AvailabilityUnknown
+	if doc, docErr := goquery.NewDocumentFromReader(strings.NewReader(pageHTML)); docErr == nil {
+		availability = extractAvailability(doc.Selection, profile, priceText != "")
+	}
+	if err == nil && priceText == "" && !availability.Purchasable() {
+		slog.Info("chromedp price not found, item unavailable",
+			slog.String("URL", url), slog.String("Availability", availability.Label()))
+		return PriceResult{Availability: availability}, nil
+	}
+	artifacts := []Artifact{
+		stageArtifact(proxy, "chromedp.jpg", screenShot),
+		stageArtifact(proxy, "chromedp.html", []byte(HTMLContent)),
 	}
 	if err != nil || priceText == "" {
 		if proxy {
-			err2 := os.WriteFile("proxyFailoverSS.png", screenShot, 0o644)
-			err3 := os.WriteFile("proxyFailoverHTML.html", []byte(HTMLContent), 0o644)
-			slog.Warn("ChromDP proxy failed, triggering non proxy", slog.Any("write err1", err2),
-				slog.Any("write err 2", err3))
-			return ChromeDPFailover(url, selector, false)
+			slog.Warn("ChromDP proxy failed, triggering non proxy", slog.Any("Error", err))
+			res, err2 := ChromeDPFailover(ctx, url, selector, false)
+			return res, withArtifacts(err2, "tracker", artifacts...)
 		} else {
-			slog.Error("no proxy ChromeDB also failed")
-			err2 := os.WriteFile("failoverSS.png", screenShot, 0o644)
-			err3 := os.WriteFile("failoverHTML.html", []byte(HTMLContent), 0o644)
 			slog.Error("error in default chromedp", slog.String("selector", selector),
-				slog.String("URL", url), slog.Any("ChromeDP Error", err),
-				slog.Any("ScreenShot Write Error", err2), slog.Any("HTML Write Error", err3))
-			return 0, fmt.Errorf("selector %s not found for url %s, %w", selector, url, err)
+				slog.String("URL", url), slog.Any("ChromeDP Error", err))
+			return PriceResult{}, withArtifacts(fmt.Errorf("selector %s not found for url %s, %w", selector, url, blocked(err)),
+				"tracker", artifacts...)
 		}
 	}
 
+	if selector == AutoSelector {
+		var price types.Money
+		var strategy string
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(priceText))
+		if err == nil {
+			price, strategy, err = extractStructuredPrice(doc.Selection, CurrencyForURL(url))
+		}
+		if err != nil {
+			return PriceResult{}, withArtifacts(fmt.Errorf("no structured price data found for url %s: %w", url, err),
+				"tracker", artifacts...)
+		}
+		return PriceResult{Price: price, Strategy: strategy, Availability: availability}, nil
+	}
+
 	slog.Info("ChromeDP found Selector", slog.String("Found HTML Element", priceText))
 	// Parse price
-	price, err := formatPrice(priceText)
-	if err != nil || price == 0 {
-		os.WriteFile("failoverHTML.html", []byte(HTMLContent), 0o644)
-		os.WriteFile("failoverSS.png", screenShot, 0o644)
-		return 0, fmt.Errorf("failed to parse price '%s': %w", priceText, err)
+	price, err := parsePrice(priceText, CurrencyForURL(url))
+	if err != nil || price.IsZero() {
+		return PriceResult{}, withArtifacts(fmt.Errorf("failed to parse price '%s': %w", priceText, err),
+			"tracker", artifacts...)
 	}
 
-	return price, nil
+	return PriceResult{Price: price, Strategy: StrategyCSS, Availability: availability}, nil
 }
 
-func GetOpenGraphPic(url string) string {
-	c := initCrawler()
+// clicks every pre click selector of the profile that exists on the page
+func preClickActions(profile SiteProfile) chromedp.Action {
+	var actions chromedp.Tasks
+	for _, selector := range profile.PreClickSelectors {
+		quoted, _ := json.Marshal(selector)
+		actions = append(actions, chromedp.Evaluate(
+			fmt.Sprintf(`document.querySelector(%s)?.click()`, quoted), nil))
+	}
+	return actions
+}
+
+func GetOpenGraphPic(ctx context.Context, url string) string {
+	c := initCrawler(true)
 	visited := false
 	imgURL := ""
-	if strings.Contains(url, "amazon") {
-		c.OnHTML("img#landingImage", func(e *colly.HTMLElement) {
-			imgURL = e.Attr("src")
-			visited = true
-		})
-	} else if strings.Contains(url, "bestbuy") {
-		c.OnHTML("div.VJYXIrZT4D0Zj6vQ img", func(e *colly.HTMLElement) {
-			imgURL = e.Attr("src")
+	profile, hasProfile := ProfileForURL(url)
+	if hasProfile && profile.ImageSelector != "" {
+		attr := profile.ImageAttribute
+		if attr == "" {
+			attr = "src"
+		}
+		c.OnHTML(profile.ImageSelector, func(e *colly.HTMLElement) {
+			imgURL = e.Attr(attr)
 			visited = true
 		})
 	} else {
@@ -264,13 +369,16 @@ func GetOpenGraphPic(url string) string {
 			visited = true
 		})
 	}
-	err := c.Visit(url)
+	var err error
+	if !profile.RequiresChromedp {
+		err = c.Visit(url)
+	}
 	if err != nil || !visited {
 		slog.Warn("could not get Open Graph picture", slog.Any("ERROR: ", err), slog.Any("Visited: ", visited))
 
-		// Fallback to chromedp for Amazon
-		if strings.Contains(url, "amazon") {
-			imgURL = getAmazonImageChromedp(url, true)
+		// Fallback to chromedp for sites with a known image selector
+		if hasProfile && profile.ImageSelector != "" {
+			imgURL = getImageChromedp(ctx, url, profile, true)
 		}
 
 		if imgURL == "" {
@@ -281,43 +389,62 @@ func GetOpenGraphPic(url string) string {
 	return imgURL
 }
 
-func getAmazonImageChromedp(url string, proxy bool) string {
-	var ctx context.Context
-	var cancel context.CancelFunc
-	if proxy {
-		ctx, cancel = NewChromedpContext(90*time.Second, chromedp.ProxyServer("http://gluetun:8888"))
-	} else {
-		ctx, cancel = NewChromedpContext(90 * time.Second)
+func getImageChromedp(ctx context.Context, url string, profile SiteProfile, proxy bool) string {
+	attr := profile.ImageAttribute
+	if attr == "" {
+		attr = "src"
 	}
-	defer cancel()
-
+	selector, _ := json.Marshal(profile.ImageSelector)
+	attrName, _ := json.Marshal(attr)
 	var imgURL string
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(url),
-		StealthActions(),
-		chromedp.Sleep(10*time.Second),
-		chromedp.Evaluate(`document.querySelector('button.a-button-text[alt="Continue shopping"]')?.click()`, nil),
-		chromedp.Sleep(2*time.Second),
-		chromedp.Evaluate(`document.querySelector('img#landingImage')?.src || ""`, &imgURL),
-	)
+	var pooled *Proxy
+	var leaseErr error
+	err := func() error {
+		var tabCtx context.Context
+		var cancel context.CancelFunc
+		tabCtx, cancel, pooled, leaseErr = leaseProxyTab(ctx, url, proxy, 90*time.Second)
+		if leaseErr != nil {
+			return leaseErr
+		}
+		defer cancel()
+		err := chromedpSnapshot(tabCtx, "image", url, chromedp.Tasks{
+			chromedp.Navigate(url),
+			StealthActions(),
+			chromedp.Sleep(10 * time.Second),
+			preClickActions(profile),
+			chromedp.Sleep(2 * time.Second),
+		},
+			// the src property is already resolved to an absolute url
+			chromedp.Evaluate(fmt.Sprintf(`((el, attr) => el ? (el[attr] || el.getAttribute(attr) || "") : "")(document.querySelector(%s), %s)`,
+				selector, attrName), &imgURL),
+		)
+		pooled.Report(err)
+		return err
+	}()
+	if leaseErr != nil {
+		return ""
+	}
+	proxy = pooled != nil
 	if err != nil {
 		if proxy {
-			slog.Warn("chromedp failed to get Amazon image, trying without proxy", slog.Any("error", err))
-			return getAmazonImageChromedp(url, false)
+			slog.Warn("chromedp failed to get image, trying without proxy",
+				slog.String("Profile", profile.Name), slog.Any("error", err))
+			return getImageChromedp(ctx, url, profile, false)
 		}
-		slog.Error("chromedp failed to get Amazon image", slog.Any("error", err))
+		slog.Error("chromedp failed to get image", slog.String("Profile", profile.Name), slog.Any("error", err))
 		return ""
 	}
 
 	return imgURL
 }
 
-func formatPrice(priceStr string) (int, error) {
-	ret := strings.ReplaceAll(priceStr, "$", "")
-	ret = strings.ReplaceAll(ret, "\n", "")
-	ret = strings.ReplaceAll(ret, ",", "")
-	ret = strings.TrimSpace(ret)
-	ret = strings.Split(ret, ".")[0]
-	res, err := strconv.Atoi(ret)
-	return res, err
+// prices without a currency symbol or code are read as the default currency
+func formatPrice(priceStr string) (types.Money, error) {
+	return types.ParseMoney(priceStr, types.DefaultCurrency)
+}
+
+// same as formatPrice for stores that do not price in the default
+// currency, see CurrencyForURL
+func parsePrice(priceStr string, currency string) (types.Money, error) {
+	return types.ParseMoney(priceStr, currency)
 }
@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+func TestCrawlPrice(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		uri          string
+		selector     string
+		page         string
+		want         types.Money
+		strategy     string
+		availability Availability
+	}{
+		{
+			name:     "css selector",
+			uri:      "https://www.example-store.com/products/framework-13",
+			selector: "span.price",
+			page:     "priceSelector.html",
+			want:     usd(129999),
+			strategy: StrategyCSS,
+		},
+		{
+			name:     "site profile fallback",
+			uri:      "https://www.newegg.com/p/N82E16814137771",
+			selector: "div.old-price-box",
+			page:     "priceNewegg.html",
+			want:     usd(54900),
+			strategy: StrategyCSS,
+		},
+		{
+			name:     "currency from the domain",
+			uri:      "https://www.example-shop.de/kopfhoerer/wh-1000xm5",
+			selector: "span.preis",
+			page:     "priceEuro.html",
+			want:     types.NewMoney(129900, "EUR"),
+			strategy: StrategyCSS,
+		},
+		{
+			name:         "json-ld",
+			uri:          "https://www.example-shop.co.uk/steam-deck-oled",
+			selector:     AutoSelector,
+			page:         "priceJSONLD.html",
+			want:         types.NewMoney(129900, "GBP"),
+			strategy:     StrategyJSONLD,
+			availability: AvailabilityInStock,
+		},
+		{
+			// no price on the page is fine when the item can't be bought
+			name:         "sold out",
+			uri:          "https://www.bestbuy.com/site/rtx-5090-founders-edition/6614151.p",
+			selector:     "div[data-testid='price-block-customer-price']",
+			page:         "priceSoldOut.html",
+			strategy:     StrategyCSS,
+			availability: AvailabilityOutOfStock,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			replayFixtures(t)
+			recordPage(t, tt.uri, tt.page)
+
+			res, err := CrawlPrice(t.Context(), tt.uri, tt.selector, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Price != tt.want || res.Strategy != tt.strategy || res.Availability != tt.availability {
+				t.Errorf("got %s %s %q, want %s %s %q", res.Price, res.Strategy, res.Availability,
+					tt.want, tt.strategy, tt.availability)
+			}
+			price, err := GetPrice(t.Context(), tt.uri, tt.selector, true)
+			if err != nil || price != tt.want {
+				t.Errorf("GetPrice got %s, %v, want %s", price, err, tt.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package crawler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"math/rand/v2"
 	"net/url"
+	"strings"
 	"time"
 
 	types "priceTracker/Types"
@@ -13,71 +15,94 @@ import (
 	"github.com/gocolly/colly/v2"
 )
 
-func depopURLGenerator(Name string, price int) string {
+type depopSource struct{}
+
+func init() {
+	RegisterSecondHandSource(depopSource{})
+}
+
+func (depopSource) Name() string { return "depop" }
+
+func (depopSource) Domain() string { return "depop.com" }
+
+func (depopSource) ItemTypes() []string { return []string{"Clothes"} }
+
+func (depopSource) Search(ctx context.Context, query SecondHandQuery) ([]*types.EbayListing, error) {
+	return CrawlDepop(query.Name, query.Price, query.titleRules("depop"))
+}
+
+func depopURLGenerator(Name string, price types.Money) string {
 	base := "https://www.depop.com/search/?q="
 	Name = url.PathEscape(Name)
-	Price := fmt.Sprintf("&_suggestion-type=recent&priceMax=%d", price)
+	Price := fmt.Sprintf("&_suggestion-type=recent&priceMax=%d", int(price.Major()))
 
 	return base + Name + Price
 }
 
-func CrawlDepop(Name string, Price int) ([]*types.EbayListing, error) {
+// lowercase text of the search page when nothing matched
+const depopNoResults = "no results"
+
+func CrawlDepop(Name string, Price types.Money, rules *TitleRules) ([]*types.EbayListing, error) {
 	url := depopURLGenerator(Name, Price)
-	c := initCrawler()
+	c := initCrawler(true)
 
 	crawlDate := time.Now()
 	retArr := []*types.EbayListing{}
 	visited := false
+	noResults := false
 	slog.Info("logging depop url", slog.String("Url", url))
+	c.OnHTML("body", func(e *colly.HTMLElement) {
+		noResults = strings.Contains(strings.ToLower(e.Text), depopNoResults)
+	})
 	c.OnHTML("ol[class^='styles_productGrid__'] li", func(e *colly.HTMLElement) {
 		visited = true
 		price, _ := formatPrice(e.ChildText("p.styles_price__H8qdh"))
-		price = int(float64(price) * TaxRate)
 		productURL := "https://depop.com" + e.ChildAttr("a", "href")
-		if price > Price {
+		if overLimit(Price, price) {
 			slog.Debug("skipping depop item, price too high",
-				slog.Int("Desired Price", Price),
-				slog.Int("item price", price))
+				slog.String("Desired Price", Price.String()),
+				slog.String("item price", price.String()))
+			rules.reject(&types.EbayListing{ItemName: Name, URL: productURL, Price: price},
+				types.RejectPrice, Price.String())
 			return
 		}
 
 		// Create NEW collector for product page
-		productCollector := initCrawler()
-		approved := false
+		productCollector := initCrawler(true)
 		condition := ""
 
 		// Handler for product page
-		r := rand.IntN(30)
-		r += r + 30
-		time.Sleep(time.Duration(r) * time.Second)
+		// no point spacing out requests that are replayed from disk
+		if fixtureMode() != fixtureReplay {
+			r := rand.IntN(30)
+			r += r + 30
+			time.Sleep(time.Duration(r) * time.Second)
+		}
 
 		productCollector.OnHTML("p.styles_textWrapper__v3kxJ", func(pe *colly.HTMLElement) {
 			condition = pe.Text
-			if titleCorrectnessCheck(condition, Name) {
-				approved = true
-			}
 		})
 
 		// Visit product page synchronously
 		productCollector.Visit(productURL)
 		productCollector.Wait()
 
-		// Now approved and condition are set
-		if approved && price < Price {
-			Listing := types.EbayListing{
-				ItemName:      Name,
-				Title:         condition,
-				Price:         price,
-				Condition:     Name,
-				URL:           productURL,
-				Date:          crawlDate,
-				Duration:      0,
-				AcceptsOffers: true,
-			}
+		// depop titles are the description, it's only set after the visit
+		Listing := types.EbayListing{
+			ItemName:      Name,
+			Title:         condition,
+			Price:         price,
+			Condition:     Name,
+			URL:           productURL,
+			Date:          crawlDate,
+			Duration:      0,
+			AcceptsOffers: true,
+		}
+		if condition != "" && rules.keep(&Listing) {
 			slog.Info("listing", slog.Any("depop listing information", Listing))
 			retArr = append(retArr, &Listing)
 		} else {
-			slog.Info("skipping depop item, title not matched or price too high",
+			slog.Info("skipping depop item, title not matched",
 				slog.String("URL", url))
 		}
 	})
@@ -85,11 +110,16 @@ func CrawlDepop(Name string, Price int) ([]*types.EbayListing, error) {
 	err := c.Visit(url)
 	c.Wait()
 
+	// nothing matched the search, not a failure of the site
+	if err == nil && !visited && noResults {
+		slog.Info("no depop listings found", slog.String("URL", url))
+		return nil, nil
+	}
 	if err != nil || !visited {
 		if err == nil {
 			err = errors.New("Depop link not visited, might have been rate limited")
 		}
-		return retArr, err
+		return retArr, blocked(err)
 	}
 
 	return retArr, nil
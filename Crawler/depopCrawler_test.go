@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+func TestCrawlDepop(t *testing.T) {
+	replayFixtures(t)
+	price := usd(15000)
+	recordPage(t, depopURLGenerator("carhartt detroit jacket", price), "depopSearch.html")
+	recordPage(t, "https://depop.com/products/vintageseller-carhartt-detroit-jacket-l/", "depopProduct.html")
+	recordPage(t, "https://depop.com/products/kidsclothes-carhartt-detroit-jacket/", "depopProductKids.html")
+	rules, rejected := testRules("carhartt detroit jacket", "Clothes", types.TitleFilter{})
+
+	listings, err := CrawlDepop("carhartt detroit jacket", price, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://depop.com/products/vintageseller-carhartt-detroit-jacket-l/"}
+	if got := listingURLs(listings); !slices.Equal(got, want) {
+		t.Fatalf("got listings %v, want %v", got, want)
+	}
+	// the product description is the title
+	if listings[0].Price != usd(12000) || listings[0].Title == "" {
+		t.Errorf("got price %s title %q", listings[0].Price, listings[0].Title)
+	}
+
+	reasons := map[string]types.RejectReason{}
+	for _, r := range *rejected {
+		reasons[r.URL] = r.Reason
+	}
+	for url, reason := range map[string]types.RejectReason{
+		"https://depop.com/products/resale-carhartt-detroit-blanket-lined/": types.RejectPrice,
+		"https://depop.com/products/kidsclothes-carhartt-detroit-jacket/":   types.RejectExcluded,
+	} {
+		if reasons[url] != reason {
+			t.Errorf("%s rejected for %q, want %q", url, reasons[url], reason)
+		}
+	}
+}
+
+// a search that matched nothing is not a failure, a page without the
+// product grid or the no results text is
+func TestCrawlDepopEmpty(t *testing.T) {
+	for _, tt := range []struct {
+		page        string
+		wantBlocked bool
+	}{
+		{"depopNoResults.html", false},
+		{"blocked.html", true},
+	} {
+		t.Run(tt.page, func(t *testing.T) {
+			replayFixtures(t)
+			price := usd(15000)
+			recordPage(t, depopURLGenerator("carhartt detroit jacket", price), tt.page)
+			rules, _ := testRules("carhartt detroit jacket", "Clothes", types.TitleFilter{})
+
+			listings, err := CrawlDepop("carhartt detroit jacket", price, rules)
+			if errors.Is(err, ErrBlocked) != tt.wantBlocked || (err != nil && !tt.wantBlocked) {
+				t.Fatalf("got error %v, want blocked %v", err, tt.wantBlocked)
+			}
+			if len(listings) != 0 {
+				t.Errorf("got listings %v", listingURLs(listings))
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	types "priceTracker/Types"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var (
+	// "3 bids" or "0 bids"
+	bidCountRegex = regexp.MustCompile(`(\d+) bids?`)
+	// "1d 4h left", "12m 30s left"
+	timeLeftRegex = regexp.MustCompile(`((?:\d+[dhms]\s*)+)left`)
+	timeUnitRegex = regexp.MustCompile(`(\d+)([dhms])`)
+)
+
+var errNoAuctionResults = fmt.Errorf("%w: no auction results found on ebay", ErrBlocked)
+
+// auctions under the price ending soonest first
+func ConstructEbayAuctionURL(Name string, newPrice types.Money) string {
+	baseURL := "https://www.ebay.com/sch/i.html?_nkw="
+	usedQuery := "&LH_ItemCondition=3000|2020|2010|1500"
+	priceQuery := fmt.Sprintf("&_udhi=%d&rt=nc", int(newPrice.Major()))
+	auction := "&LH_Auction=1&_sop=1"
+	location := "&_stpos=90274&_fcid=1"
+	return baseURL + url.PathEscape(Name) + usedQuery + priceQuery + auction + location
+}
+
+// ebay only shows the time left, the end time is guessed from the crawl time
+func parseTimeLeft(text string, crawlDate time.Time) (time.Time, bool) {
+	match := timeLeftRegex.FindStringSubmatch(text)
+	if match == nil {
+		return time.Time{}, false
+	}
+	var left time.Duration
+	for _, part := range timeUnitRegex.FindAllStringSubmatch(match[1], -1) {
+		n, _ := strconv.Atoi(part[1])
+		switch part[2] {
+		case "d":
+			left += time.Duration(n) * 24 * time.Hour
+		case "h":
+			left += time.Duration(n) * time.Hour
+		case "m":
+			left += time.Duration(n) * time.Minute
+		case "s":
+			left += time.Duration(n) * time.Second
+		}
+	}
+	return crawlDate.Add(left), true
+}
+
+// same as GetEbayListings but for auctions, Price is the current bid
+func GetEbayAuctions(Name string, desiredPrice types.Money, rules *TitleRules, Proxy bool) ([]*types.EbayListing, error) {
+	uri := ConstructEbayAuctionURL(Name, desiredPrice)
+	Proxy = useProxy(Proxy)
+	slog.Info(uri, slog.Bool("proxy", Proxy))
+	var listingArr []*types.EbayListing
+	crawlDate := time.Now()
+	visited := false
+	c := initCrawler(Proxy)
+	c.OnHTML("ul.srp-results > li", func(e *colly.HTMLElement) {
+		visited = true
+		title := e.ChildText(".s-card__title span.primary")
+		condition := e.ChildText("div.s-card__subtitle")
+		link := strings.Split(e.ChildAttr("a.s-card__link", "href"), "?_skw")[0]
+		endTime, ok := parseTimeLeft(e.Text, crawlDate)
+		if !ok {
+			// cards from the "fewer words" section can be buy it now
+			return
+		}
+
+		// first row is the current bid, the bid count and delivery come after
+		var basePrice, shippingCost types.Money
+		var err error
+		bids := 0
+		e.ForEach("div.s-card__attribute-row", func(i int, child *colly.HTMLElement) {
+			if i == 0 {
+				basePrice, err = formatPrice(child.Text)
+			} else if match := bidCountRegex.FindStringSubmatch(child.Text); match != nil {
+				bids, _ = strconv.Atoi(match[1])
+			} else if strings.Contains(child.Text, "delivery") && !strings.Contains(child.Text, "Free") {
+				shippingCost, _ = formatPrice(child.Text)
+			}
+		})
+		if basePrice.IsZero() || err != nil {
+			slog.Warn("bid 0 something is wrong for", slog.Any("Error", err), slog.String("URL", link))
+			return
+		}
+
+		listing := types.EbayListing{
+			ItemName:  Name,
+			Price:     basePrice,
+			Shipping:  shippingCost,
+			URL:       link,
+			Title:     title,
+			Condition: condition,
+			Date:      crawlDate,
+			Type:      types.ListingAuction,
+			BidCount:  bids,
+			EndTime:   endTime,
+		}
+		if !rules.keep(&listing) {
+			return
+		}
+		if overLimit(desiredPrice, basePrice, shippingCost) {
+			rules.reject(&listing, types.RejectPrice, desiredPrice.String())
+			return
+		}
+		slog.Info("auction", slog.Any("ebay auction information", listing))
+		listingArr = append(listingArr, &listing)
+	})
+	err := c.Visit(uri)
+	c.Wait()
+	if (err != nil || !visited) && Proxy {
+		slog.Warn("ebay auction crawl failed, redoing request without proxy", slog.Any("Error", err))
+		return GetEbayAuctions(Name, desiredPrice, rules, false)
+	}
+	if err == nil && !visited {
+		err = errNoAuctionResults
+	}
+	return listingArr, blocked(err)
+}
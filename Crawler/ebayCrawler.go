@@ -1,12 +1,11 @@
 package crawler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/url"
-	"os"
-	"regexp"
 	"strings"
 	"time"
 
@@ -17,39 +16,35 @@ import (
 	types "priceTracker/Types"
 
 	"github.com/chromedp/chromedp"
-	"github.com/dlclark/regexp2"
 	"github.com/gocolly/colly/v2"
 )
 
-type GeocodeResponse struct {
-	Results []Location `json:"results"`
-}
+type ebaySource struct{}
 
-type Location struct {
-	Lat float64 `json:"lat"`
-	Lon float64 `json:"lon"`
-}
-type Body struct {
-	Mode    string        `json:"mode"`
-	Sources []coordinates `json:"sources"`
-	Targets []coordinates `json:"targets"`
-	Units   string        `json:"units"`
-}
-type coordinates struct {
-	Location [2]float64 `json:"location"`
-}
-type dist struct {
-	Distance float64 `json:"distance"`
-	Time     float64 `json:"time"`
+func init() {
+	RegisterSecondHandSource(ebaySource{})
 }
-type distanceRes struct {
-	Sources_to_targets [][]dist `json:"sources_to_targets"`
+
+func (ebaySource) Name() string { return "ebay" }
+
+func (ebaySource) Domain() string { return "ebay.com" }
+
+func (ebaySource) ItemTypes() []string { return nil }
+
+func (ebaySource) Search(ctx context.Context, query SecondHandQuery) ([]*types.EbayListing, error) {
+	rules := query.titleRules("ebay")
+	listings, err := GetEbayListings(ctx, query.Name, query.Price, rules, true)
+	if !query.Auctions {
+		return listings, err
+	}
+	auctions, auctionErr := GetEbayAuctions(query.Name, query.Price, rules, true)
+	return append(listings, auctions...), errors.Join(err, auctionErr)
 }
 
-func ConstructEbaySearchURL(Name string, newPrice int) string {
+func ConstructEbaySearchURL(Name string, newPrice types.Money) string {
 	baseURL := "https://www.ebay.com/sch/i.html?_nkw="
 	usedQuery := "&LH_ItemCondition=3000|2020|2010|1500"
-	priceQuery := fmt.Sprintf("&_udhi=%d&rt=nc", newPrice)
+	priceQuery := fmt.Sprintf("&_udhi=%d&rt=nc", int(newPrice.Major()))
 	noAuction := "&LH_BIN=1"
 	location := "&_stpos=90274&_fcid=1"
 	return baseURL + url.PathEscape(Name) + usedQuery + priceQuery + noAuction + location
@@ -58,31 +53,23 @@ func ConstructEbaySearchURL(Name string, newPrice int) string {
 // returns a map of urls and prices + shipping cost
 // it returns an error on items that are local pickup only
 // since they dont have a shipping fee div
-func GetEbayListings(Name string, desiredPrice int, Proxy bool) ([]*types.EbayListing, error) {
+func GetEbayListings(ctx context.Context, Name string, desiredPrice types.Money, rules *TitleRules, Proxy bool) ([]*types.EbayListing, error) {
 	url := ConstructEbaySearchURL(Name, desiredPrice)
+	Proxy = useProxy(Proxy)
 
 	slog.Info(url, slog.Bool("proxy", Proxy))
 	var listingArr []*types.EbayListing
 	crawlDate := time.Now()
 	visited := false
-	c := initCrawler()
-	if !Proxy {
-		c.SetProxyFunc(nil)
-	}
+	c := initCrawler(Proxy)
 	c.OnHTML("ul.srp-results > li", func(e *colly.HTMLElement) {
 		visited = true
 		title := e.ChildText(".s-card__title span.primary")
-
-		// check to see if listing is viable
-		if !titleCorrectnessCheck(title, Name) {
-			slog.Info("skipping title criteria not met", slog.String("Title", title))
-			return
-		}
 		condition := e.ChildText("div.s-card__subtitle")
 
 		// first one is price, second one is wether its bid or normal "or best offer" GetEbayListings
 		// thid is delivery price +$12.00 delivery in 2-4 days
-		var basePrice, shippingCost int
+		var basePrice, shippingCost types.Money
 		var err error
 		var acceptsOffers bool
 		e.ForEachWithBreak("div.s-card__attribute-row", func(i int, child *colly.HTMLElement) bool {
@@ -90,7 +77,6 @@ func GetEbayListings(Name string, desiredPrice int, Proxy bool) ([]*types.EbayLi
 			case 0:
 				// get base price
 				basePrice, err = formatPrice(child.Text)
-				basePrice = int(float64(basePrice) * TaxRate)
 			case 1:
 				// skip bids, no need to add them to the return bid array
 				if strings.Contains(child.Text, "or Best Offer") {
@@ -99,7 +85,7 @@ func GetEbayListings(Name string, desiredPrice int, Proxy bool) ([]*types.EbayLi
 			case 2:
 				// get shipping price
 				if strings.Contains(child.Text, "Free delivery") {
-					shippingCost = 0
+					shippingCost = types.Money{}
 				} else {
 					shippingCost, err = formatPrice(child.Text)
 				}
@@ -110,18 +96,16 @@ func GetEbayListings(Name string, desiredPrice int, Proxy bool) ([]*types.EbayLi
 		})
 		link := e.ChildAttr("a.s-card__link", "href")
 		// skip item if any errors are met
-		if basePrice == 0 || err != nil {
+		if basePrice.IsZero() || err != nil {
 			slog.Warn("price 0 something is wrong for", slog.Any("Error", err),
-				slog.Int("baseprice", basePrice), slog.String("URL", link))
-			return
-		} else if basePrice+shippingCost >= desiredPrice {
-			slog.Info("price too high skipping title", slog.String("Title", title))
+				slog.String("baseprice", basePrice.String()), slog.String("URL", link))
 			return
 		}
 
 		listing := types.EbayListing{
 			ItemName: Name,
-			Price:    shippingCost + basePrice,
+			Price:    basePrice,
+			Shipping: shippingCost,
 			// it has metadata from search after url, this leans it up
 			URL:           strings.Split(link, "?_skw")[0],
 			Title:         title,
@@ -130,6 +114,15 @@ func GetEbayListings(Name string, desiredPrice int, Proxy bool) ([]*types.EbayLi
 			Date:          crawlDate,
 			Duration:      0,
 		}
+		// check to see if listing is viable
+		if !rules.keep(&listing) {
+			return
+		}
+		if overLimit(desiredPrice, basePrice, shippingCost) {
+			slog.Info("price too high skipping title", slog.String("Title", title))
+			rules.reject(&listing, types.RejectPrice, desiredPrice.String())
+			return
+		}
 		slog.Info("listing", slog.Any("ebay listing information", listing))
 		listingArr = append(listingArr, &listing)
 	})
@@ -138,62 +131,60 @@ func GetEbayListings(Name string, desiredPrice int, Proxy bool) ([]*types.EbayLi
 	if err != nil || !visited {
 		if !Proxy {
 			slog.Warn("Colly failed even without proxy triggering chromeDP")
-			listingArr, err = EbayFailover(url, desiredPrice, Name)
+			listingArr, err = EbayFailover(ctx, url, desiredPrice, Name, rules)
 			return listingArr, err
 		}
 		slog.Warn("ebay failed, redoing request without proxy")
-		listingArr, err = GetEbayListings(Name, desiredPrice, false)
+		listingArr, err = GetEbayListings(ctx, Name, desiredPrice, rules, false)
 		return listingArr, err
 	}
 	return listingArr, err
 }
 
-func EbayFailover(url string, desiredPrice int, Name string) ([]*types.EbayListing, error) {
+func EbayFailover(ctx context.Context, url string, desiredPrice types.Money, Name string, rules *TitleRules) ([]*types.EbayListing, error) {
 	crawlDate := time.Now()
 	slog.Info("chromedp failover for ebay", slog.String("URL", url))
-	ctx, cancel := NewChromedpContext(90 * time.Second)
-	defer cancel()
-
 	var first []byte
 	var second []byte
-	var items []types.EbayListing
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(url),
-		StealthActions(),
-		chromedp.Sleep(10*time.Second),
-		chromedp.FullScreenshot(&first, 70),
-		chromedp.Sleep(3*time.Second),
-		chromedp.FullScreenshot(&second, 70),
-		chromedp.Evaluate(`
+	// prices come back as page text and are parsed in go so the
+	// cents and currency are kept
+	var items []struct {
+		Title         string
+		Condition     string
+		URL           string
+		AcceptsOffers bool
+		PriceText     string
+		ShippingText  string
+	}
+	err := func() error {
+		tabCtx, cancel, err := leaseTab(ctx, 90*time.Second, "")
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		return blocked(chromedpSnapshot(tabCtx, "ebay", url, chromedp.Tasks{
+			chromedp.Navigate(url),
+			StealthActions(),
+			chromedp.Sleep(10 * time.Second),
+			chromedp.FullScreenshot(&first, 70),
+			chromedp.Sleep(3 * time.Second),
+			chromedp.FullScreenshot(&second, 70),
+		}, chromedp.Evaluate(`
 		Array.from(document.querySelectorAll('ul.srp-results > li')).map(e => {
 				const rows = e.querySelectorAll('div.s-card__attribute-row');
-				let basePrice = 0;
-				let shippingCost = 0;
-				let AcceptsOffer = false
-				
-				// Format price function (converted from Go)
-				const formatPrice = (priceStr) => {
-						if (!priceStr) return 0;
-						let ret = priceStr.replace(/\$/g, '');
-						ret = ret.replace(/,/g, '');
-						ret = ret.trim();
-						ret = ret.split('.')[0];
-						return parseInt(ret) || 0;
-				};
+				let priceText = '';
+				let shippingText = '';
+				let acceptsOffers = false;
 				
 				for (let i = 0; i < Math.min(3, rows.length); i++) {
 						if (i === 0) {
-								basePrice = formatPrice(rows[i].innerText);
+								priceText = rows[i].innerText;
 						}
 						if (i === 1 && rows[i].innerText.includes('or Best Offer')) {
-								AcceptsOffer = true;
+								acceptsOffers = true;
 						}
-						if (i === 2) {
-								if (rows[i].innerText.includes('Free delivery')) {
-										shippingCost = 0;
-								} else {
-										shippingCost = formatPrice(rows[i].innerText);
-								}
+						if (i === 2 && !rows[i].innerText.includes('Free delivery')) {
+								shippingText = rows[i].innerText;
 						}
 				}
 				
@@ -201,104 +192,53 @@ func EbayFailover(url string, desiredPrice int, Name string) ([]*types.EbayListi
 						Title: e.querySelector('.s-card__title span.primary')?.innerText || '',
 						Condition: e.querySelector('div.s-card__subtitle')?.innerText || '',
 						URL: e.querySelector('a.s-card__link')?.href || '',
-						AcceptsOffer: AcceptsOffer,
-						Price: shippingCost + basePrice
+						AcceptsOffers: acceptsOffers,
+						PriceText: priceText,
+						ShippingText: shippingText
 				};
 		}).filter(item => item !== null)
-		`, &items),
-	)
+		`, &items)))
+	}()
 	var retArr []*types.EbayListing
 
+	artifacts := []Artifact{{Name: "first.jpg", Data: first}, {Name: "second.jpg", Data: second}}
 	if err != nil {
-		fileErr1 := os.WriteFile("ebayFirst.png", first, 0o644)
-		fileErr2 := os.WriteFile("ebaySecond.png", second, 0o644)
-		slog.Error("Error in ebay failover", slog.Any("error value", err),
-			slog.Any("file error 1", fileErr1), slog.Any("file error 2", fileErr2))
-		return retArr, errors.Join(err, errors.New("Problem in Ebay chromeDP Failover"))
+		slog.Error("Error in ebay failover", slog.Any("error value", err))
+		return retArr, withArtifacts(errors.Join(err, errors.New("Problem in Ebay chromeDP Failover")),
+			"ebay", artifacts...)
 	} else if len(items) == 0 {
-		return retArr, errors.New("no items returned from Ebay chromeDP, check screenshots for sanity check")
+		return retArr, withArtifacts(blocked(errors.New("no items returned from Ebay chromeDP, check screenshots for sanity check")),
+			"ebay", artifacts...)
 	}
 	slog.Info("Ebay Failover returned Items, its fine for now")
 	// <------------------ sanitize the list ------------>
-	for i := range items {
-		if titleCorrectnessCheck(items[i].Title, Name) && items[i].Price != 0 &&
-			items[i].Price < desiredPrice {
-			items[i].ItemName = Name
-			items[i].URL = strings.Split(items[i].URL, "?_skw")[0]
-			items[i].Price = int(float64(items[i].Price) * TaxRate)
-			items[i].Date = crawlDate
-			items[i].Duration = 0
-			retArr = append(retArr, &items[i])
-		}
-	}
-	return retArr, err
-}
-
-var excludeRegexes []*regexp2.Regexp
-
-func init() {
-	excludePatterns := []string{
-		`\bfor parts`,
-		`\bbroken`,
-		`\baccessories\b`,
-		`(?=.*\bonly\b)(?=.*\bbox\b)`,
-		`\bempty box`,
-		`\bcable\b`,
-		`\bdongle\b`,
-		`\bkids\b`,
-		`\bjunior\b`,
-		`read`,
-		`\bstand\b`,
-		`\badapter\b`,
-		`\bdefective`,
-		`damage`,
-		`problem`,
-	}
-
-	for _, pattern := range excludePatterns {
-		re, err := regexp2.Compile(pattern, 0)
-		if err != nil {
+	for _, item := range items {
+		basePrice, _ := formatPrice(item.PriceText)
+		shippingCost, _ := formatPrice(item.ShippingText)
+		if basePrice.IsZero() {
 			continue
 		}
-		excludeRegexes = append(excludeRegexes, re)
-	}
-}
-
-// checks the title to make sure the name is in the title and
-// no unwanted returned results by ebay
-func titleCorrectnessCheck(listingTitle string, itemName string) bool {
-	words := strings.Fields(strings.ToLower(itemName))
-	listingTitle = strings.ToLower(listingTitle)
-
-	replacer := strings.NewReplacer(
-		".", " ",
-		"'", " ",
-		"'", " ",
-	)
-	listingTitle = replacer.Replace(listingTitle)
-
-	// Check all words from itemName are in listingTitle
-	for _, word := range words {
-		pattern := `\b` + regexp.QuoteMeta(word) + `\b`
-		matched, _ := regexp.MatchString(pattern, listingTitle)
-		if !matched {
-			return false // Word not found
+		listing := &types.EbayListing{
+			ItemName:      Name,
+			Title:         item.Title,
+			Condition:     item.Condition,
+			URL:           strings.Split(item.URL, "?_skw")[0],
+			AcceptsOffers: item.AcceptsOffers,
+			Price:         basePrice,
+			Shipping:      shippingCost,
+			Date:          crawlDate,
+			Duration:      0,
 		}
-	}
-
-	// Exclude titles with unwanted keywords
-	for _, re := range excludeRegexes {
-		match, err := re.MatchString(listingTitle)
-		if err != nil {
+		if !rules.keep(listing) {
 			continue
 		}
-
-		if match {
-			return false // Excluded keyword found
+		if overLimit(desiredPrice, basePrice, shippingCost) {
+			rules.reject(listing, types.RejectPrice, desiredPrice.String())
+			continue
 		}
+		retArr = append(retArr, listing)
 	}
-
-	return true // Title is valid
+	return retArr, err
 }
 
 // i dont need this anymore but ill keep it just in case
@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"slices"
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+func TestGetEbayListings(t *testing.T) {
+	replayFixtures(t)
+	price := usd(30000)
+	recordPage(t, ConstructEbaySearchURL("rtx 3060 ti", price), "ebaySearch.html")
+	rules, rejected := testRules("rtx 3060 ti", "Tech", types.TitleFilter{})
+
+	listings, err := GetEbayListings(t.Context(), "rtx 3060 ti", price, rules, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://www.ebay.com/itm/1001", "https://www.ebay.com/itm/1002"}
+	if got := listingURLs(listings); !slices.Equal(got, want) {
+		t.Fatalf("got listings %v, want %v", got, want)
+	}
+
+	first := listings[0]
+	if first.Price != usd(24999) || first.Shipping != usd(1550) {
+		t.Errorf("got price %s shipping %s, want $249.99 and $15.50", first.Price, first.Shipping)
+	}
+	if !first.AcceptsOffers || first.Condition != "Pre-Owned" || first.Relevance != 1 {
+		t.Errorf("got offers %v condition %q relevance %v", first.AcceptsOffers, first.Condition, first.Relevance)
+	}
+	if !listings[1].Shipping.IsZero() || listings[1].AcceptsOffers {
+		t.Errorf("free delivery buy it now listing read as shipping %s offers %v",
+			listings[1].Shipping, listings[1].AcceptsOffers)
+	}
+
+	reasons := map[string]types.RejectReason{}
+	for _, r := range *rejected {
+		reasons[r.URL] = r.Reason
+	}
+	for url, reason := range map[string]types.RejectReason{
+		"https://www.ebay.com/itm/1003": types.RejectExcluded,
+		"https://www.ebay.com/itm/1004": types.RejectPrice,
+		"https://www.ebay.com/itm/1005": types.RejectTitleMatch,
+	} {
+		if reasons[url] != reason {
+			t.Errorf("%s rejected for %q, want %q", url, reasons[url], reason)
+		}
+	}
+}
+
+// without a recorded search page the colly crawl fails and the chromedp
+// failover snapshot is used
+func TestGetEbayListingsFailover(t *testing.T) {
+	replayFixtures(t)
+	price := usd(30000)
+	recordSnapshot(t, "ebay", ConstructEbaySearchURL("rtx 3060 ti", price), "ebayFailover.html")
+	rules, rejected := testRules("rtx 3060 ti", "Tech", types.TitleFilter{})
+
+	listings, err := GetEbayListings(t.Context(), "rtx 3060 ti", price, rules, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := listingURLs(listings); !slices.Equal(got, []string{"https://www.ebay.com/itm/2001"}) {
+		t.Fatalf("got listings %v", got)
+	}
+	if listings[0].Price != usd(24000) || listings[0].Shipping != usd(1000) {
+		t.Errorf("got price %s shipping %s", listings[0].Price, listings[0].Shipping)
+	}
+	if len(*rejected) != 1 || (*rejected)[0].Reason != types.RejectExcluded {
+		t.Errorf("got rejections %+v, want the box only listing excluded", *rejected)
+	}
+}
@@ -0,0 +1,110 @@
+package crawler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	types "priceTracker/Types"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// the search page came back without any cards, usually a captcha
+var errNoSoldResults = fmt.Errorf("%w: no sold results found on ebay", ErrBlocked)
+
+// sold cards have a caption like "Sold  Oct 12, 2025"
+var soldDateRegex = regexp.MustCompile(`Sold\s+([A-Z][a-z]{2} \d{1,2}, \d{4})`)
+
+// completed listings that sold, newest first. ebay only keeps about
+// 90 days of these
+func ConstructEbaySoldURL(Name string) string {
+	baseURL := "https://www.ebay.com/sch/i.html?_nkw="
+	usedQuery := "&LH_ItemCondition=3000|2020|2010|1500"
+	soldQuery := "&LH_Sold=1&LH_Complete=1&_sop=13&rt=nc"
+	location := "&_stpos=90274&_fcid=1"
+	return baseURL + url.PathEscape(Name) + usedQuery + soldQuery + location
+}
+
+// crawls the ebay sold results of the item, prices are raw usd with the
+// shipping kept separate so the caller can add tax and convert them.
+// titles go through the same rules as the active listings but nothing
+// gets reported as rejected, these are only used for stats
+func GetEbaySoldListings(Name string, ItemType string, Filter types.TitleFilter) ([]*types.SoldListing, error) {
+	if err := breakerAllow("ebay.com"); err != nil {
+		return nil, err
+	}
+	rules := NewTitleRules(Name, ItemType, Filter)
+	sold, err := crawlEbaySold(ConstructEbaySoldURL(Name), Name, rules, true)
+	breakerRecord("ebay.com", err)
+	return sold, err
+}
+
+// there is no chromedp failover for these, a missed day just waits for
+// the next one
+func crawlEbaySold(uri string, Name string, rules *TitleRules, Proxy bool) ([]*types.SoldListing, error) {
+	Proxy = useProxy(Proxy)
+	slog.Info(uri, slog.Bool("proxy", Proxy))
+	var soldArr []*types.SoldListing
+	visited := false
+	c := initCrawler(Proxy)
+	c.OnHTML("ul.srp-results > li", func(e *colly.HTMLElement) {
+		visited = true
+		title := e.ChildText(".s-card__title span.primary")
+		link := strings.Split(e.ChildAttr("a.s-card__link", "href"), "?_skw")[0]
+		match := soldDateRegex.FindStringSubmatch(e.Text)
+		if match == nil {
+			// the "results matching fewer words" cards have no sold date
+			return
+		}
+		soldDate, err := time.Parse("Jan 2, 2006", match[1])
+		if err != nil {
+			slog.Warn("could not parse sold date", slog.String("Date", match[1]), slog.String("URL", link))
+			return
+		}
+
+		// first row is the final price, delivery is in one of the later rows
+		var basePrice, shippingCost types.Money
+		e.ForEach("div.s-card__attribute-row", func(i int, child *colly.HTMLElement) {
+			if i == 0 {
+				basePrice, err = formatPrice(child.Text)
+			} else if strings.Contains(child.Text, "delivery") && !strings.Contains(child.Text, "Free") {
+				shippingCost, _ = formatPrice(child.Text)
+			}
+		})
+		if basePrice.IsZero() || err != nil {
+			slog.Warn("sold price 0 something is wrong for", slog.Any("Error", err), slog.String("URL", link))
+			return
+		}
+
+		score, reason, rule := titleCorrectnessCheck(title, rules)
+		if reason != "" && !rules.allowed(link) {
+			slog.Info("skipping sold title criteria not met", slog.String("Title", title),
+				slog.String("Reason", string(reason)), slog.String("Rule", rule))
+			return
+		}
+		soldArr = append(soldArr, &types.SoldListing{
+			ItemName:  Name,
+			Title:     title,
+			URL:       link,
+			Price:     basePrice,
+			RawPrice:  basePrice,
+			Shipping:  shippingCost,
+			SoldDate:  soldDate,
+			Relevance: score,
+		})
+	})
+	err := c.Visit(uri)
+	c.Wait()
+	if (err != nil || !visited) && Proxy {
+		slog.Warn("ebay sold crawl failed, redoing request without proxy", slog.Any("Error", err))
+		return crawlEbaySold(uri, Name, rules, false)
+	}
+	if err == nil && !visited {
+		err = errNoSoldResults
+	}
+	return soldArr, blocked(err)
+}
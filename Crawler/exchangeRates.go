@@ -0,0 +1,211 @@
+package crawler
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	types "priceTracker/Types"
+)
+
+// rates are units of each currency per one unit of the base currency
+type ExchangeRates struct {
+	Base    string             `json:"base"`
+	Updated time.Time          `json:"updated"`
+	Rates   map[string]float64 `json:"rates"`
+}
+
+//go:embed exchangeRates.json
+var defaultExchangeRates []byte
+
+var (
+	exchangeRates     ExchangeRates
+	exchangeRatesOnce sync.Once
+	exchangeRatesMu   sync.RWMutex
+	// swapped out to point refreshes at a stub server
+	ExchangeRateClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// the stored table is read from EXCHANGE_RATES_PATH so conversions work
+// offline, the bundled table is only used until the first refresh
+func exchangeRatesPath() string {
+	if path := os.Getenv("EXCHANGE_RATES_PATH"); path != "" {
+		return path
+	}
+	return "exchangeRates.json"
+}
+
+func loadExchangeRates() {
+	data := defaultExchangeRates
+	if file, err := os.ReadFile(exchangeRatesPath()); err == nil {
+		data = file
+	} else if !errors.Is(err, os.ErrNotExist) {
+		slog.Error("could not read exchange rates, using defaults", slog.Any("Error", err))
+	}
+	var rates ExchangeRates
+	if err := json.Unmarshal(data, &rates); err != nil || len(rates.Rates) == 0 {
+		slog.Error("could not parse exchange rates, using defaults", slog.Any("Error", err))
+		rates = ExchangeRates{}
+		json.Unmarshal(defaultExchangeRates, &rates)
+	}
+	exchangeRatesMu.Lock()
+	exchangeRates = rates
+	exchangeRatesMu.Unlock()
+	slog.Info("exchange rates loaded", slog.String("Base", rates.Base),
+		slog.Time("Updated", rates.Updated), slog.Int("Currencies", len(rates.Rates)))
+}
+
+func GetExchangeRates() ExchangeRates {
+	exchangeRatesOnce.Do(loadExchangeRates)
+	exchangeRatesMu.RLock()
+	defer exchangeRatesMu.RUnlock()
+	return exchangeRates
+}
+
+// fetches the latest table from EXCHANGE_RATE_URL and stores it, does
+// nothing when the url is not set. the endpoint has to return a base and
+// a rates object, base_code is accepted as well
+func RefreshExchangeRates() error {
+	endpoint := os.Getenv("EXCHANGE_RATE_URL")
+	if endpoint == "" {
+		return nil
+	}
+	exchangeRatesOnce.Do(loadExchangeRates)
+	resp, err := ExchangeRateClient.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("could not fetch exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("exchange rate endpoint returned %s", resp.Status)
+	}
+	var body struct {
+		Base     string             `json:"base"`
+		BaseCode string             `json:"base_code"`
+		Rates    map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("could not decode exchange rates: %w", err)
+	}
+	rates := ExchangeRates{
+		Base:    strings.ToUpper(body.Base),
+		Updated: time.Now(),
+		Rates:   body.Rates,
+	}
+	if rates.Base == "" {
+		rates.Base = strings.ToUpper(body.BaseCode)
+	}
+	if rates.Base == "" || len(rates.Rates) == 0 {
+		return errors.New("exchange rate response has no base or rates")
+	}
+	rates.Rates[rates.Base] = 1
+
+	data, err := json.MarshalIndent(rates, "", "\t")
+	if err == nil {
+		err = os.WriteFile(exchangeRatesPath(), data, 0o644)
+	}
+	if err != nil {
+		slog.Error("could not store exchange rates", slog.Any("Error", err))
+	}
+	exchangeRatesMu.Lock()
+	exchangeRates = rates
+	exchangeRatesMu.Unlock()
+	slog.Info("exchange rates refreshed", slog.String("Base", rates.Base),
+		slog.Int("Currencies", len(rates.Rates)))
+	return nil
+}
+
+// converts through the base currency of the table, money that is already
+// in the target currency is returned as it is
+func ConvertMoney(m types.Money, currency string) (types.Money, error) {
+	if m.Currency == "" {
+		m.Currency = types.DefaultCurrency
+	}
+	if currency == "" {
+		currency = types.DefaultCurrency
+	}
+	if m.Currency == currency {
+		return m, nil
+	}
+	rates := GetExchangeRates()
+	from, ok := rates.Rates[m.Currency]
+	if !ok || from == 0 {
+		return m, fmt.Errorf("no exchange rate for %s", m.Currency)
+	}
+	to, ok := rates.Rates[currency]
+	if !ok || to == 0 {
+		return m, fmt.Errorf("no exchange rate for %s", currency)
+	}
+	major := m.Major() / from * to
+	if math.IsInf(major, 0) || math.IsNaN(major) {
+		return m, fmt.Errorf("invalid conversion from %s to %s", m.Currency, currency)
+	}
+	return types.MoneyFromMajor(major, currency), nil
+}
+
+// compares like Money.Cmp after converting a to the currency of b, for
+// prices that can come from different currencies
+func CompareMoney(a types.Money, b types.Money) (int, error) {
+	converted, err := ConvertMoney(a, b.Currency)
+	if err != nil {
+		return 0, err
+	}
+	return converted.Cmp(b), nil
+}
+
+// listings can be priced in another currency than the limit, like a
+// foreign ebay seller, so the prices are converted before they are added
+// up. a price that can't be converted counts as over the limit
+func overLimit(limit types.Money, prices ...types.Money) bool {
+	total := types.Money{Currency: limit.Currency}
+	for _, p := range prices {
+		converted, err := ConvertMoney(p, limit.Currency)
+		if err != nil {
+			slog.Warn("could not compare listing price to the limit",
+				slog.String("Price", p.String()), slog.Any("Error", err))
+			return true
+		}
+		total = total.Add(converted)
+	}
+	return total.Cmp(limit) >= 0
+}
+
+// currency a store prices in when the page only shows a bare amount or a
+// $ sign, guessed from the country domain
+var domainCurrencies = []struct {
+	suffix   string
+	currency string
+}{
+	{".co.uk", "GBP"}, {".uk", "GBP"},
+	{".com.au", "AUD"}, {".au", "AUD"},
+	{".co.nz", "NZD"}, {".nz", "NZD"},
+	{".co.jp", "JPY"}, {".jp", "JPY"},
+	{".com.mx", "MXN"}, {".mx", "MXN"},
+	{".com.br", "BRL"}, {".in", "INR"}, {".ca", "CAD"}, {".ch", "CHF"},
+	{".se", "SEK"}, {".dk", "DKK"}, {".pl", "PLN"},
+	{".de", "EUR"}, {".fr", "EUR"}, {".it", "EUR"}, {".es", "EUR"},
+	{".nl", "EUR"}, {".be", "EUR"}, {".at", "EUR"}, {".ie", "EUR"},
+}
+
+func CurrencyForURL(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return types.DefaultCurrency
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, d := range domainCurrencies {
+		if strings.HasSuffix(host, d.suffix) {
+			return d.currency
+		}
+	}
+	return types.DefaultCurrency
+}
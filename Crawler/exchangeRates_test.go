@@ -0,0 +1,141 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+// stores rates in a temp file and sends refreshes to handler, the bundled
+// table is what's loaded until the first refresh
+func stubExchangeRates(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	path := filepath.Join(t.TempDir(), "exchangeRates.json")
+	t.Setenv("EXCHANGE_RATES_PATH", path)
+	t.Setenv("EXCHANGE_RATE_URL", srv.URL)
+	client := ExchangeRateClient
+	ExchangeRateClient = srv.Client()
+	exchangeRatesOnce = sync.Once{}
+	t.Cleanup(func() {
+		ExchangeRateClient = client
+		exchangeRatesOnce = sync.Once{}
+	})
+	return path
+}
+
+func TestRefreshExchangeRates(t *testing.T) {
+	path := stubExchangeRates(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "success", "base_code": "eur", "rates": {"USD": 1.25, "GBP": 0.8}}`))
+	})
+
+	if err := RefreshExchangeRates(); err != nil {
+		t.Fatal(err)
+	}
+	rates := GetExchangeRates()
+	if rates.Base != "EUR" || rates.Rates["EUR"] != 1 || rates.Rates["USD"] != 1.25 {
+		t.Fatalf("got rates %+v", rates)
+	}
+	for _, tt := range []struct {
+		from types.Money
+		to   string
+		want types.Money
+	}{
+		{types.NewMoney(12500, "USD"), "EUR", types.NewMoney(10000, "EUR")},
+		{types.NewMoney(12500, "USD"), "GBP", types.NewMoney(8000, "GBP")},
+		{types.NewMoney(8000, "GBP"), "GBP", types.NewMoney(8000, "GBP")},
+	} {
+		if got, err := ConvertMoney(tt.from, tt.to); err != nil || got != tt.want {
+			t.Errorf("ConvertMoney(%s, %s) = %s, %v, want %s", tt.from, tt.to, got, err, tt.want)
+		}
+	}
+	if _, err := ConvertMoney(types.NewMoney(100, "USD"), "JPY"); err == nil {
+		t.Error("expected an error for a currency the refreshed table doesn't have")
+	}
+
+	// foreign listings are converted to the limit before they are compared
+	limit := types.NewMoney(10000, "EUR")
+	for _, tt := range []struct {
+		prices []types.Money
+		want   bool
+	}{
+		{[]types.Money{types.NewMoney(9000, "EUR")}, false},
+		{[]types.Money{types.NewMoney(12500, "USD")}, true},
+		{[]types.Money{types.NewMoney(10000, "USD"), types.NewMoney(1000, "GBP")}, false},
+		{[]types.Money{types.NewMoney(10000, "USD"), types.NewMoney(4000, "GBP")}, true},
+		{[]types.Money{types.NewMoney(100, "JPY")}, true},
+	} {
+		if got := overLimit(limit, tt.prices...); got != tt.want {
+			t.Errorf("overLimit(%s, %v) = %v, want %v", limit, tt.prices, got, tt.want)
+		}
+	}
+
+	// the stored table is used after a restart
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("rates were not stored: %v", err)
+	}
+	exchangeRatesOnce = sync.Once{}
+	if rates := GetExchangeRates(); rates.Base != "EUR" || rates.Rates["GBP"] != 0.8 {
+		t.Errorf("got rates %+v after reloading", rates)
+	}
+}
+
+// a failed refresh keeps the table it had
+func TestRefreshExchangeRatesFailure(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"server error", http.StatusInternalServerError, `{}`, true},
+		{"no rates", http.StatusOK, `{"base": "USD", "rates": {}}`, true},
+		{"not json", http.StatusOK, `<html>rate limited</html>`, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stubExchangeRates(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			before := GetExchangeRates()
+			if err := RefreshExchangeRates(); (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if after := GetExchangeRates(); after.Base != before.Base || len(after.Rates) != len(before.Rates) {
+				t.Errorf("rates changed from %s to %s", before.Base, after.Base)
+			}
+		})
+	}
+}
+
+func TestRefreshExchangeRatesDisabled(t *testing.T) {
+	stubExchangeRates(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("refresh went out without EXCHANGE_RATE_URL")
+	})
+	t.Setenv("EXCHANGE_RATE_URL", "")
+	if err := RefreshExchangeRates(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCurrencyForURL(t *testing.T) {
+	for uri, want := range map[string]string{
+		"https://www.amazon.co.uk/dp/B0C":     "GBP",
+		"https://www.amazon.de/dp/B0C":        "EUR",
+		"https://www.bestbuy.ca/en-ca/p/1":    "CAD",
+		"https://www.bestbuy.com/site/1.p":    "USD",
+		"https://shop.example.com.au/item/12": "AUD",
+		"not a url %zz":                       "USD",
+	} {
+		if got := CurrencyForURL(uri); got != want {
+			t.Errorf("CurrencyForURL(%s) = %s, want %s", uri, got, want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// CRAWL_FIXTURE_MODE=record saves every http response and chromedp page
+// under CRAWL_FIXTURE_DIR, replay serves them back without touching the
+// network so parsing can be checked offline
+const (
+	fixtureOff    = ""
+	fixtureRecord = "record"
+	fixtureReplay = "replay"
+)
+
+var errNoFixture = errors.New("no fixture recorded")
+
+func fixtureMode() string {
+	switch mode := os.Getenv("CRAWL_FIXTURE_MODE"); mode {
+	case fixtureRecord, fixtureReplay:
+		return mode
+	case fixtureOff:
+	default:
+		slog.Warn("unknown fixture mode, crawling live", slog.String("Mode", mode))
+	}
+	return fixtureOff
+}
+
+func fixtureDir() string {
+	if dir := os.Getenv("CRAWL_FIXTURE_DIR"); dir != "" {
+		return dir
+	}
+	return "fixtures"
+}
+
+// fixtures are named after the host so the folder stays readable, the
+// hash keeps different pages of the same site apart. ext is the file
+// extension with its dot
+func fixturePath(kind string, key string, ext string) string {
+	host := "unknown"
+	if u, err := url.Parse(key); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	sum := sha1.Sum([]byte(key))
+	name := safePathPart(host) + "-" + hex.EncodeToString(sum[:])[:12] + ext
+	return filepath.Join(fixtureDir(), kind, name)
+}
+
+func saveFixture(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func loadFixture(path string, key string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w for %s", errNoFixture, key)
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+type httpFixture struct {
+	Method string
+	URL    string
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type fixtureTransport struct {
+	mode string
+	next http.RoundTripper
+}
+
+// wraps the collector transport when a fixture mode is set
+func withFixtures(next http.RoundTripper) http.RoundTripper {
+	mode := fixtureMode()
+	if mode == fixtureOff {
+		return next
+	}
+	return &fixtureTransport{mode: mode, next: next}
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + req.URL.String()
+	path := fixturePath("http", req.URL.String()+"#"+req.Method, ".json")
+	if t.mode == fixtureReplay {
+		var f httpFixture
+		if err := loadFixture(path, key, &f); err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+			StatusCode:    f.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        f.Header,
+			Body:          io.NopCloser(bytes.NewReader(f.Body)),
+			ContentLength: int64(len(f.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	f := httpFixture{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   body,
+	}
+	if err := saveFixture(path, f); err != nil {
+		slog.Error("could not record fixture", slog.String("URL", f.URL), slog.Any("Error", err))
+	} else {
+		slog.Info("recorded fixture", slog.String("URL", f.URL), slog.String("Path", path))
+	}
+	return resp, nil
+}
+
+// chromedp crawls can't be replayed at the http level, so the rendered
+// page is recorded instead. load gets the page ready in the tab, clicks
+// and waits included, and read takes the values out of it. in replay mode
+// the recorded page is put in the tab in place of load, so read runs the
+// same js against it
+func chromedpSnapshot(ctx context.Context, kind string, uri string, load chromedp.Action, read chromedp.Action) error {
+	path := fixturePath("chromedp-"+kind, uri, ".html")
+	switch fixtureMode() {
+	case fixtureReplay:
+		page, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w for %s", errNoFixture, uri)
+		} else if err != nil {
+			return err
+		}
+		return chromedp.Run(ctx, replayPage(uri, page), read)
+	case fixtureRecord:
+		var page string
+		err := chromedp.Run(ctx, load,
+			chromedp.Evaluate(`document.documentElement.outerHTML`, &page),
+			read,
+		)
+		if err != nil {
+			return err
+		}
+		if saveErr := os.MkdirAll(filepath.Dir(path), 0o755); saveErr != nil {
+			slog.Error("could not record chromedp fixture", slog.String("URL", uri), slog.Any("Error", saveErr))
+		} else if saveErr := os.WriteFile(path, []byte(page), 0o644); saveErr != nil {
+			slog.Error("could not record chromedp fixture", slog.String("URL", uri), slog.Any("Error", saveErr))
+		} else {
+			slog.Info("recorded chromedp fixture", slog.String("URL", uri), slog.String("Path", path))
+		}
+		return nil
+	}
+	return chromedp.Run(ctx, load, read)
+}
+
+// serves the recorded page as uri so links resolve like they did on the
+// live site. every other request is failed and the scripts of the page are
+// turned off, the recorded dom is already rendered
+func replayPage(uri string, page []byte) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		chromedp.ListenTarget(ctx, func(ev any) {
+			paused, ok := ev.(*fetch.EventRequestPaused)
+			if !ok {
+				return
+			}
+			go func() {
+				var err error
+				if paused.ResourceType == network.ResourceTypeDocument {
+					err = fetch.FulfillRequest(paused.RequestID, http.StatusOK).
+						WithResponseHeaders([]*fetch.HeaderEntry{{Name: "Content-Type", Value: "text/html; charset=utf-8"}}).
+						WithBody(base64.StdEncoding.EncodeToString(page)).
+						Do(ctx)
+				} else {
+					err = fetch.FailRequest(paused.RequestID, network.ErrorReasonBlockedByClient).Do(ctx)
+				}
+				if err != nil && ctx.Err() == nil {
+					slog.Warn("could not answer replayed request", slog.String("URL", paused.Request.URL), slog.Any("Error", err))
+				}
+			}()
+		})
+		return chromedp.Tasks{
+			emulation.SetScriptExecutionDisabled(true),
+			fetch.Enable(),
+			chromedp.Navigate(uri),
+		}.Do(ctx)
+	})
+}
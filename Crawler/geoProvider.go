@@ -0,0 +1,325 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Location struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// GeoProvider turns location text into coordinates and routes between
+// them, picked with GEO_PROVIDER so self hosters can go without geoapify
+type GeoProvider interface {
+	Name() string
+	Geocode(location string) (Location, error)
+	// drive distance in miles and time in seconds, an error makes the
+	// caller fall back to the straight line distance
+	Route(from Location, to Location) (float64, float64, error)
+}
+
+var (
+	geoProvider     GeoProvider
+	geoProviderOnce sync.Once
+	// swapped out to point geo requests at a stub server
+	GeoClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// GEO_PROVIDER is geoapify, osm or static. without it geoapify is used
+// when GEO_API_KEY is set and the public osm servers otherwise
+func getGeoProvider() GeoProvider {
+	geoProviderOnce.Do(func() {
+		name := strings.ToLower(os.Getenv("GEO_PROVIDER"))
+		if name == "" {
+			name = "osm"
+			if os.Getenv("GEO_API_KEY") != "" {
+				name = "geoapify"
+			}
+		}
+		switch name {
+		case "static":
+			geoProvider = newStaticGeoProvider()
+		case "osm", "nominatim":
+			geoProvider = newOSMGeoProvider()
+		default:
+			if name != "geoapify" {
+				slog.Error("unknown geo provider, using geoapify", slog.String("Provider", name))
+			}
+			geoProvider = geoapifyGeoProvider{
+				baseURL: envOr("GEO_API_URL", "https://api.geoapify.com/v1"),
+				apiKey:  os.Getenv("GEO_API_KEY"),
+			}
+		}
+		slog.Info("geo provider selected", slog.String("Provider", geoProvider.Name()))
+	})
+	return geoProvider
+}
+
+func envOr(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	return fallback
+}
+
+func geoGet(uri string, out any) error {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	// nominatim turns away requests without a user agent
+	req.Header.Set("User-Agent", geoUserAgent())
+	return geoDo(req, out)
+}
+
+// the public osm servers want to know who is sending requests, GEO_CONTACT
+// (an email or url) is added to the user agent for that
+func geoUserAgent() string {
+	if contact := os.Getenv("GEO_CONTACT"); contact != "" {
+		return "priceTracker (" + contact + ")"
+	}
+	return "priceTracker"
+}
+
+// spaces requests to a server at least interval apart, callers wait their
+// turn
+type geoThrottle struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+func (t *geoThrottle) wait() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if d := time.Until(t.next); d > 0 {
+		time.Sleep(d)
+	}
+	t.next = time.Now().Add(t.interval)
+}
+
+func geoDo(req *http.Request, out any) error {
+	res, err := GeoClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, res.Status)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// --------------------------- geoapify ------------------------------------- //
+
+type geoapifyGeoProvider struct {
+	baseURL string
+	apiKey  string
+}
+
+type GeocodeResponse struct {
+	Results []Location `json:"results"`
+}
+
+type Body struct {
+	Mode    string        `json:"mode"`
+	Sources []coordinates `json:"sources"`
+	Targets []coordinates `json:"targets"`
+	Units   string        `json:"units"`
+}
+type coordinates struct {
+	Location [2]float64 `json:"location"`
+}
+type dist struct {
+	Distance float64 `json:"distance"`
+	Time     float64 `json:"time"`
+}
+type distanceRes struct {
+	Sources_to_targets [][]dist `json:"sources_to_targets"`
+}
+
+func (geoapifyGeoProvider) Name() string { return "geoapify" }
+
+func (g geoapifyGeoProvider) Geocode(location string) (Location, error) {
+	uri := g.baseURL + "/geocode/search?text=" + url.PathEscape(location) +
+		"&format=json&apiKey=" + g.apiKey
+	var result GeocodeResponse
+	if err := geoGet(uri, &result); err != nil {
+		return Location{}, err
+	}
+	if len(result.Results) == 0 {
+		return Location{}, fmt.Errorf("no results found")
+	}
+	return result.Results[0], nil
+}
+
+func (g geoapifyGeoProvider) Route(from Location, to Location) (float64, float64, error) {
+	reqBody := Body{
+		Mode:    "drive",
+		Sources: []coordinates{{Location: [2]float64{from.Lon, from.Lat}}},
+		Targets: []coordinates{{Location: [2]float64{to.Lon, to.Lat}}},
+		Units:   "imperial",
+	}
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, 0, err
+	}
+	req, err := http.NewRequest("POST", g.baseURL+"/routematrix?&format=json&apiKey="+g.apiKey,
+		bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	var d distanceRes
+	if err := geoDo(req, &d); err != nil {
+		return 0, 0, err
+	}
+	if len(d.Sources_to_targets) == 0 || len(d.Sources_to_targets[0]) == 0 {
+		return 0, 0, fmt.Errorf("empty array returned from geo")
+	}
+	return d.Sources_to_targets[0][0].Distance, d.Sources_to_targets[0][0].Time, nil
+}
+
+// --------------------------- nominatim + osrm ----------------------------- //
+
+const (
+	publicNominatimURL = "https://nominatim.openstreetmap.org"
+	publicOSRMURL      = "https://router.project-osrm.org"
+)
+
+// anything that speaks the nominatim search and osrm route apis, the public
+// servers by default or self hosted ones
+type osmGeoProvider struct {
+	nominatimURL string
+	osrmURL      string
+	// set for the public servers, which allow one request a second
+	nominatimThrottle *geoThrottle
+	osrmThrottle      *geoThrottle
+}
+
+func newOSMGeoProvider() osmGeoProvider {
+	o := osmGeoProvider{
+		nominatimURL: envOr("NOMINATIM_URL", publicNominatimURL),
+		osrmURL:      envOr("OSRM_URL", publicOSRMURL),
+	}
+	if o.nominatimURL == publicNominatimURL {
+		o.nominatimThrottle = &geoThrottle{interval: time.Second}
+	}
+	if o.osrmURL == publicOSRMURL {
+		o.osrmThrottle = &geoThrottle{interval: time.Second}
+	}
+	if (o.nominatimThrottle != nil || o.osrmThrottle != nil) && os.Getenv("GEO_CONTACT") == "" {
+		slog.Warn("using the public osm servers without GEO_CONTACT, they may block requests " +
+			"that don't say who sent them")
+	}
+	return o
+}
+
+func (osmGeoProvider) Name() string { return "osm" }
+
+func (o osmGeoProvider) Geocode(location string) (Location, error) {
+	uri := o.nominatimURL + "/search?format=json&limit=1&q=" + url.QueryEscape(location)
+	o.nominatimThrottle.wait()
+	// nominatim sends the coordinates as strings
+	var results []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := geoGet(uri, &results); err != nil {
+		return Location{}, err
+	}
+	if len(results) == 0 {
+		return Location{}, fmt.Errorf("no results found")
+	}
+	lat, err := strconv.ParseFloat(results[0].Lat, 64)
+	if err != nil {
+		return Location{}, err
+	}
+	lon, err := strconv.ParseFloat(results[0].Lon, 64)
+	if err != nil {
+		return Location{}, err
+	}
+	return Location{Lat: lat, Lon: lon}, nil
+}
+
+func (o osmGeoProvider) Route(from Location, to Location) (float64, float64, error) {
+	uri := fmt.Sprintf("%s/route/v1/driving/%f,%f;%f,%f?overview=false",
+		o.osrmURL, from.Lon, from.Lat, to.Lon, to.Lat)
+	o.osrmThrottle.wait()
+	// distance is in meters
+	var result struct {
+		Code   string `json:"code"`
+		Routes []struct {
+			Distance float64 `json:"distance"`
+			Duration float64 `json:"duration"`
+		} `json:"routes"`
+	}
+	if err := geoGet(uri, &result); err != nil {
+		return 0, 0, err
+	}
+	if result.Code != "Ok" || len(result.Routes) == 0 {
+		return 0, 0, fmt.Errorf("no route found, osrm returned %s", result.Code)
+	}
+	return result.Routes[0].Distance / 1609.344, result.Routes[0].Duration, nil
+}
+
+// --------------------------- static table --------------------------------- //
+
+// locations from a json file at GEO_STATIC_PATH like
+// {"torrance, ca": {"lat": 33.83, "lon": -118.34}}, for running fully
+// offline. there is no routing so distances are straight lines
+type staticGeoProvider struct {
+	table map[string]Location
+}
+
+func newStaticGeoProvider() staticGeoProvider {
+	path := envOr("GEO_STATIC_PATH", "geoStatic.json")
+	table := map[string]Location{}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &table)
+	}
+	if err != nil {
+		slog.Error("could not load static geo table", slog.String("Path", path), slog.Any("Error", err))
+	}
+	// keys are matched the same way as the geocode cache
+	normalized := make(map[string]Location, len(table))
+	for k, v := range table {
+		normalized[geocodeKey(k)] = v
+	}
+	return staticGeoProvider{table: normalized}
+}
+
+func (staticGeoProvider) Name() string { return "static" }
+
+func (s staticGeoProvider) Geocode(location string) (Location, error) {
+	loc, ok := s.table[geocodeKey(location)]
+	if !ok {
+		return Location{}, fmt.Errorf("%s is not in the static geo table", location)
+	}
+	return loc, nil
+}
+
+func (staticGeoProvider) Route(from Location, to Location) (float64, float64, error) {
+	return 0, 0, errors.New("the static geo provider has no routing")
+}
@@ -0,0 +1,208 @@
+package crawler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// the provider is picked again from the env on the next lookup and the
+// geocode cache starts empty
+func resetGeo(t *testing.T) {
+	t.Helper()
+	t.Setenv("GEOCODE_CACHE_PATH", filepath.Join(t.TempDir(), "geocodeCache.json"))
+	geocodeCacheOnce = sync.Once{}
+	geoProviderOnce = sync.Once{}
+	geoProvider = nil
+	t.Cleanup(func() {
+		geocodeCacheOnce = sync.Once{}
+		geoProviderOnce = sync.Once{}
+		geoProvider = nil
+	})
+}
+
+func useGeoProvider(t *testing.T, provider GeoProvider) {
+	t.Helper()
+	resetGeo(t)
+	geoProviderOnce.Do(func() { geoProvider = provider })
+}
+
+// sends geo requests to handler, the returned url stands in for the api
+func stubGeoServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	client := GeoClient
+	GeoClient = srv.Client()
+	t.Cleanup(func() { GeoClient = client })
+	return srv.URL
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateDistanceGeoapify(t *testing.T) {
+	resetGeo(t)
+	var geocodes atomic.Int32
+	var routeDown atomic.Bool
+	uri := stubGeoServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if key := r.URL.Query().Get("apiKey"); key != "test-key" {
+			t.Errorf("got api key %q", key)
+		}
+		switch r.URL.Path {
+		case "/v1/geocode/search":
+			geocodes.Add(1)
+			writeJSON(t, w, map[string]any{"results": []Location{{Lat: 33.7701, Lon: -118.1937}}})
+		case "/v1/routematrix":
+			if routeDown.Load() {
+				http.Error(w, "overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			var body Body
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Mode != "drive" {
+				t.Errorf("got route request %+v, %v", body, err)
+			}
+			writeJSON(t, w, map[string]any{"sources_to_targets": [][]dist{{{Distance: 12.34, Time: 1500}}}})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	t.Setenv("GEO_PROVIDER", "")
+	t.Setenv("GEO_API_KEY", "test-key")
+	t.Setenv("GEO_API_URL", uri+"/v1/")
+
+	for _, tt := range []struct {
+		maxDistance, maxDriveTime int
+		want                      bool
+	}{
+		{25, 0, true},
+		{25, 30, true},
+		{10, 0, false},
+		// too long a drive even though it's close enough
+		{25, 20, false},
+	} {
+		nearby, distStr, err := ValidateDistance("Long Beach, CA", 33.8358, -118.3406, tt.maxDistance, tt.maxDriveTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nearby != tt.want || distStr != "12.3 miles, currently 25 min ETA" {
+			t.Errorf("limit %d miles %d min got %v %q, want %v", tt.maxDistance, tt.maxDriveTime, nearby, distStr, tt.want)
+		}
+	}
+
+	// a failed route falls back to the straight line instead of dropping it
+	routeDown.Store(true)
+	nearby, distStr, err := ValidateDistance("long beach, ca ", 33.8358, -118.3406, 25, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nearby || !strings.HasSuffix(distStr, "miles in a straight line, no ETA") {
+		t.Errorf("got %v %q", nearby, distStr)
+	}
+	if getGeoProvider().Name() != "geoapify" {
+		t.Errorf("got provider %s", getGeoProvider().Name())
+	}
+	// the location is only geocoded once and the cache is kept on disk
+	if n := geocodes.Load(); n != 1 {
+		t.Errorf("geocoded %d times", n)
+	}
+	data, err := os.ReadFile(os.Getenv("GEOCODE_CACHE_PATH"))
+	if err != nil || !strings.Contains(string(data), `"long beach, ca"`) {
+		t.Errorf("got cache %s, %v", data, err)
+	}
+}
+
+func TestValidateDistanceOSM(t *testing.T) {
+	resetGeo(t)
+	var routeDown atomic.Bool
+	uri := stubGeoServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "priceTracker (ops@example.com)" {
+			t.Errorf("got user agent %q", ua)
+		}
+		switch {
+		case r.URL.Path == "/search":
+			if q := r.URL.Query().Get("q"); q != "Long Beach, CA" {
+				t.Errorf("got query %q", q)
+			}
+			writeJSON(t, w, []map[string]string{{"lat": "33.7701", "lon": "-118.1937"}})
+		case strings.HasPrefix(r.URL.Path, "/route/v1/driving/"):
+			if routeDown.Load() {
+				http.Error(w, "overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			writeJSON(t, w, map[string]any{
+				"code":   "Ok",
+				"routes": []map[string]float64{{"distance": 16093.44, "duration": 900}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	t.Setenv("GEO_PROVIDER", "osm")
+	t.Setenv("NOMINATIM_URL", uri)
+	t.Setenv("OSRM_URL", uri)
+	t.Setenv("GEO_CONTACT", "ops@example.com")
+
+	nearby, distStr, err := ValidateDistance("Long Beach, CA", 33.8358, -118.3406, 25, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nearby || distStr != "10.0 miles, currently 15 min ETA" {
+		t.Errorf("got %v %q", nearby, distStr)
+	}
+	// self hosted servers are not throttled
+	if o := getGeoProvider().(osmGeoProvider); o.nominatimThrottle != nil || o.osrmThrottle != nil {
+		t.Error("got throttles for self hosted servers")
+	}
+
+	// the drive time can't be checked without a route
+	routeDown.Store(true)
+	nearby, distStr, err = ValidateDistance("Long Beach, CA", 33.8358, -118.3406, 25, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nearby || !strings.HasSuffix(distStr, "miles in a straight line, no ETA") {
+		t.Errorf("got %v %q", nearby, distStr)
+	}
+}
+
+func TestValidateDistanceGeocodeFailure(t *testing.T) {
+	useGeoProvider(t, staticGeoProvider{table: map[string]Location{}})
+	if _, _, err := ValidateDistance("Nowhere", 33.8358, -118.3406, 25, 0); err == nil {
+		t.Fatal("expected an error for a location that can't be geocoded")
+	}
+}
+
+func TestNewOSMGeoProviderPublic(t *testing.T) {
+	t.Setenv("NOMINATIM_URL", "")
+	t.Setenv("OSRM_URL", "")
+	o := newOSMGeoProvider()
+	if o.nominatimURL != publicNominatimURL || o.nominatimThrottle == nil || o.osrmThrottle == nil {
+		t.Errorf("got %+v, the public servers need throttles", o)
+	}
+}
+
+func TestGeoThrottle(t *testing.T) {
+	var unset *geoThrottle
+	unset.wait()
+
+	throttle := &geoThrottle{interval: 20 * time.Millisecond}
+	start := time.Now()
+	for range 3 {
+		throttle.wait()
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 requests went out in %s", elapsed)
+	}
+}
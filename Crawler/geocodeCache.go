@@ -0,0 +1,82 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	geocodeCache     map[string]Location
+	geocodeCacheOnce sync.Once
+	geocodeCacheMu   sync.Mutex
+)
+
+// marketplace locations repeat a lot, so geocode results are kept on disk
+// at GEOCODE_CACHE_PATH and survive restarts
+func geocodeCachePath() string {
+	if path := os.Getenv("GEOCODE_CACHE_PATH"); path != "" {
+		return path
+	}
+	return "geocodeCache.json"
+}
+
+func loadGeocodeCache() {
+	geocodeCache = map[string]Location{}
+	data, err := os.ReadFile(geocodeCachePath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Error("could not read geocode cache", slog.Any("Error", err))
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &geocodeCache); err != nil {
+		slog.Error("could not parse geocode cache, starting empty", slog.Any("Error", err))
+		geocodeCache = map[string]Location{}
+	}
+	slog.Info("geocode cache loaded", slog.Int("Locations", len(geocodeCache)))
+}
+
+// "Torrance, CA" and "torrance, ca " are the same place
+func geocodeKey(location string) string {
+	return strings.ToLower(strings.Join(strings.Fields(location), " "))
+}
+
+func cachedCoordinates(location string) (Location, bool) {
+	geocodeCacheOnce.Do(loadGeocodeCache)
+	geocodeCacheMu.Lock()
+	defer geocodeCacheMu.Unlock()
+	loc, ok := geocodeCache[geocodeKey(location)]
+	return loc, ok
+}
+
+// the whole cache is rewritten, it only grows by one small entry at a time
+func cacheCoordinates(location string, loc Location) {
+	geocodeCacheOnce.Do(loadGeocodeCache)
+	geocodeCacheMu.Lock()
+	defer geocodeCacheMu.Unlock()
+	geocodeCache[geocodeKey(location)] = loc
+	data, err := json.MarshalIndent(geocodeCache, "", "\t")
+	if err == nil {
+		err = os.WriteFile(geocodeCachePath(), data, 0o644)
+	}
+	if err != nil {
+		slog.Error("could not store geocode cache", slog.Any("Error", err))
+	}
+}
+
+// straight line distance in miles, used when routing is down. it is
+// always shorter than the drive so listings are let through more easily
+func haversineMiles(lat1, long1, lat2, long2 float64) float64 {
+	const earthRadiusMiles = 3958.8
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLong := toRad(long2 - long1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusMiles * math.Asin(math.Sqrt(a))
+}
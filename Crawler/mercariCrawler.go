@@ -0,0 +1,141 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	types "priceTracker/Types"
+
+	"github.com/chromedp/chromedp"
+)
+
+type mercariSource struct{}
+
+func init() {
+	RegisterSecondHandSource(mercariSource{})
+}
+
+func (mercariSource) Name() string { return "mercari" }
+
+func (mercariSource) Domain() string { return "mercari.com" }
+
+func (mercariSource) ItemTypes() []string { return nil }
+
+func (mercariSource) Search(ctx context.Context, query SecondHandQuery) ([]*types.EbayListing, error) {
+	return CrawlMercari(ctx, query.Name, query.Price, query.titleRules("mercari"), true)
+}
+
+// items that are still for sale under the price, newest first
+func MercariURLGenerator(Name string, Price types.Money) string {
+	baseURL := "https://www.mercari.com/search/?keyword="
+	// mercari takes the price in cents
+	priceQuery := fmt.Sprintf("&maxPrice=%d", int(Price.Major())*100)
+	return baseURL + url.QueryEscape(Name) + priceQuery + "&itemStatuses=1&sortBy=2"
+}
+
+// the search results are rendered with js, so this goes through chromedp
+// like facebook. every mercari listing takes offers
+func CrawlMercari(ctx context.Context, Name string, desiredPrice types.Money, rules *TitleRules, proxy bool) ([]*types.EbayListing, error) {
+	crawlDate := time.Now()
+	uri := MercariURLGenerator(Name, desiredPrice)
+	slog.Info("crawling mercari URL", slog.String("URL", uri))
+	var screenshot []byte
+	var items []struct {
+		Title        string
+		URL          string
+		PriceText    string
+		ShippingText string
+	}
+	// the results container rendered, so no items means nothing matched
+	// instead of a blocked or broken page
+	var noResults bool
+	var pooled *Proxy
+	var leaseErr error
+	err := func() error {
+		var tabCtx context.Context
+		var cancel context.CancelFunc
+		tabCtx, cancel, pooled, leaseErr = leaseProxyTab(ctx, uri, proxy, 90*time.Second)
+		if leaseErr != nil {
+			return leaseErr
+		}
+		defer cancel()
+		err := chromedpSnapshot(tabCtx, "mercari", uri, chromedp.Tasks{
+			chromedp.Navigate(uri),
+			StealthActions(),
+			chromedp.Sleep(10 * time.Second),
+			chromedp.FullScreenshot(&screenshot, 70),
+		}, chromedp.Tasks{
+			chromedp.Evaluate(`
+			Array.from(document.querySelectorAll('[data-testid="ItemContainer"]')).map(e => ({
+					Title: e.querySelector('[data-testid="ItemName"]')?.innerText || e.getAttribute('aria-label') || '',
+					URL: (e.closest('a') || e.querySelector('a'))?.href || '',
+					PriceText: e.querySelector('[data-testid="ItemPrice"]')?.innerText || '',
+					ShippingText: e.innerText.split('\n').find(l => /shipping/i.test(l)) || '',
+			}))
+			`, &items),
+			chromedp.Evaluate(`!!document.querySelector('[data-testid="SearchResults"]') ||
+				/no results/i.test(document.body.innerText)`, &noResults),
+		})
+		pooled.Report(err)
+		return err
+	}()
+	if leaseErr != nil {
+		return nil, leaseErr
+	}
+	proxy = pooled != nil
+
+	if err == nil && len(items) == 0 && noResults {
+		slog.Info("no mercari listings found", slog.String("URL", uri))
+		return nil, nil
+	}
+	var retArr []*types.EbayListing
+	if err != nil || len(items) == 0 {
+		artifacts := []Artifact{stageArtifact(proxy, "first.jpg", screenshot)}
+		if proxy {
+			slog.Warn("mercari proxy failed, triggering no proxy crawl", slog.Any("Error", err))
+			retArr, err = CrawlMercari(ctx, Name, desiredPrice, rules, false)
+			return retArr, withArtifacts(err, "mercari", artifacts...)
+		}
+		if err == nil {
+			err = errors.New("no items returned from mercari, check the screenshot")
+		}
+		err = errors.Join(errors.New("Error in mercari:"), blocked(err))
+		return retArr, withArtifacts(err, "mercari", artifacts...)
+	}
+	for _, item := range items {
+		price, _ := formatPrice(item.PriceText)
+		if price.IsZero() {
+			continue
+		}
+		// mercari always ships, the cost is only on the item page unless
+		// the card has a free or flat shipping badge. search cards don't
+		// show the condition
+		shipping, shippingKnown := parseShippingText(item.ShippingText)
+		listing := &types.EbayListing{
+			ItemName:      Name,
+			Title:         item.Title,
+			Price:         price,
+			Shipping:      shipping,
+			Ships:         true,
+			ShippingKnown: shippingKnown,
+			URL:           strings.Split(item.URL, "?")[0],
+			Date:          crawlDate,
+			Duration:      0,
+			AcceptsOffers: true,
+		}
+		if !rules.keep(listing) {
+			continue
+		}
+		if overLimit(desiredPrice, price, shipping) {
+			rules.reject(listing, types.RejectPrice, desiredPrice.String())
+			continue
+		}
+		retArr = append(retArr, listing)
+	}
+	return retArr, nil
+}
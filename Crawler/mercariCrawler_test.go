@@ -0,0 +1,65 @@
+package crawler
+
+import (
+	"slices"
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+func TestCrawlMercari(t *testing.T) {
+	replayFixtures(t)
+	price := usd(30000)
+	recordSnapshot(t, "mercari", MercariURLGenerator("switch oled", price), "mercariSearch.html")
+	rules, rejected := testRules("switch oled", "Tech", types.TitleFilter{})
+
+	listings, err := CrawlMercari(t.Context(), "switch oled", price, rules, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://www.mercari.com/us/item/m81234567890/", "https://www.mercari.com/us/item/m81234567891/"}
+	if got := listingURLs(listings); !slices.Equal(got, want) {
+		t.Fatalf("got listings %v, want %v", got, want)
+	}
+	if l := listings[0]; l.Price != usd(21500) || !l.Ships || !l.ShippingKnown || !l.Shipping.IsZero() {
+		t.Errorf("got price %s shipping %s known %v for free shipping", l.Price, l.Shipping, l.ShippingKnown)
+	}
+	if l := listings[1]; !l.Ships || l.ShippingKnown {
+		t.Errorf("got shipping known %v for a card without a shipping badge", l.ShippingKnown)
+	}
+
+	// the last rejection is only over the price with its shipping
+	var reasons []types.RejectReason
+	for _, r := range *rejected {
+		reasons = append(reasons, r.Reason)
+	}
+	if !slices.Equal(reasons, []types.RejectReason{types.RejectExcluded, types.RejectPrice, types.RejectPrice}) {
+		t.Errorf("got rejections %v", reasons)
+	}
+}
+
+func TestCrawlMercariEmpty(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		snapshot string
+		wantErr  bool
+	}{
+		{"nothing matched", "mercariNoResults.html", false},
+		{"no results container", "mercariBlocked.html", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			replayFixtures(t)
+			price := usd(30000)
+			recordSnapshot(t, "mercari", MercariURLGenerator("switch oled", price), tt.snapshot)
+			rules, _ := testRules("switch oled", "Tech", types.TitleFilter{})
+
+			listings, err := CrawlMercari(t.Context(), "switch oled", price, rules, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if len(listings) != 0 {
+				t.Errorf("got listings %v", listingURLs(listings))
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	types "priceTracker/Types"
+
+	"github.com/chromedp/chromedp"
+)
+
+type offerupSource struct{}
+
+func init() {
+	RegisterSecondHandSource(offerupSource{})
+}
+
+func (offerupSource) Name() string { return "offerup" }
+
+func (offerupSource) Domain() string { return "offerup.com" }
+
+func (offerupSource) ItemTypes() []string { return nil }
+
+func (offerupSource) Search(ctx context.Context, query SecondHandQuery) ([]*types.EbayListing, error) {
+	return CrawlOfferUp(ctx, query.Name, query.Price, query.titleRules("offerup"),
+		query.Lat, query.Long, query.Distance, query.MaxDriveTime, true)
+}
+
+func OfferUpURLGenerator(Name string, Price types.Money) string {
+	baseURL := "https://offerup.com/search?q="
+	priceQuery := fmt.Sprintf("&price_max=%d", int(Price.Major()))
+	return baseURL + url.QueryEscape(Name) + priceQuery + "&SORT=-posted"
+}
+
+// splits the text of a result card into its price, title, location and
+// shipping badge, cards read like "$120\nTitle\nTorrance, CA" with the
+// badge mixed in
+func parseOfferUpCard(text string) (priceText string, title string, location string, shippingText string) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case isOfferUpBadge(line):
+			shippingText = line
+		case priceText == "" && strings.HasPrefix(line, "$"):
+			priceText = line
+		case title == "":
+			title = line
+		default:
+			location = line
+		}
+	}
+	return priceText, title, location, shippingText
+}
+
+// shipping badges get their own line on the card
+func isOfferUpBadge(line string) bool {
+	line = strings.ToLower(line)
+	return strings.HasPrefix(line, "ships") || strings.HasPrefix(line, "shipping") ||
+		strings.HasPrefix(line, "free shipping")
+}
+
+// results only render with js, so this goes through chromedp like facebook.
+// posts that can't be shipped go through the same distance check as
+// facebook listings
+func CrawlOfferUp(ctx context.Context, Name string, desiredPrice types.Money, rules *TitleRules, homeLat, homeLong float64,
+	maxDistance int, maxDriveTime int, proxy bool,
+) ([]*types.EbayListing, error) {
+	crawlDate := time.Now()
+	uri := OfferUpURLGenerator(Name, desiredPrice)
+	slog.Info("crawling offerup URL", slog.String("URL", uri))
+	var screenshot []byte
+	var items []struct {
+		URL  string
+		Text string
+	}
+	// the page says nothing matched, as opposed to a blocked or broken page
+	var noResults bool
+	var pooled *Proxy
+	var leaseErr error
+	err := func() error {
+		var tabCtx context.Context
+		var cancel context.CancelFunc
+		tabCtx, cancel, pooled, leaseErr = leaseProxyTab(ctx, uri, proxy, 90*time.Second)
+		if leaseErr != nil {
+			return leaseErr
+		}
+		defer cancel()
+		err := chromedpSnapshot(tabCtx, "offerup", uri, chromedp.Tasks{
+			chromedp.Navigate(uri),
+			StealthActions(),
+			chromedp.Sleep(10 * time.Second),
+			chromedp.FullScreenshot(&screenshot, 70),
+		}, chromedp.Tasks{
+			chromedp.Evaluate(`
+			Array.from(document.querySelectorAll('a[href*="/item/detail/"]')).map(e => ({
+					URL: e.href,
+					Text: e.innerText,
+			}))
+			`, &items),
+			chromedp.Evaluate(`/no (results|items|listings) found|couldn.t find/i.test(document.body.innerText)`, &noResults),
+		})
+		pooled.Report(err)
+		return err
+	}()
+	if leaseErr != nil {
+		return nil, leaseErr
+	}
+	proxy = pooled != nil
+
+	if err == nil && len(items) == 0 && noResults {
+		slog.Info("no offerup listings found", slog.String("URL", uri))
+		return nil, nil
+	}
+	var retArr []*types.EbayListing
+	if err != nil || len(items) == 0 {
+		artifacts := []Artifact{stageArtifact(proxy, "first.jpg", screenshot)}
+		if proxy {
+			slog.Warn("offerup proxy failed, triggering no proxy crawl", slog.Any("Error", err))
+			retArr, err = CrawlOfferUp(ctx, Name, desiredPrice, rules, homeLat, homeLong, maxDistance, maxDriveTime, false)
+			return retArr, withArtifacts(err, "offerup", artifacts...)
+		}
+		if err == nil {
+			err = errors.New("no items returned from offerup, check the screenshot")
+		}
+		err = errors.Join(errors.New("Error in offerup:"), blocked(err))
+		return retArr, withArtifacts(err, "offerup", artifacts...)
+	}
+	for _, item := range items {
+		priceText, title, location, shippingText := parseOfferUpCard(item.Text)
+		price, _ := formatPrice(priceText)
+		if price.IsZero() {
+			continue
+		}
+		ships := shippingText != ""
+		shipping, shippingKnown := parseShippingText(shippingText)
+		// cards show where the post is instead of its condition
+		listing := &types.EbayListing{
+			ItemName:      Name,
+			Title:         title,
+			Price:         price,
+			Shipping:      shipping,
+			Ships:         ships,
+			ShippingKnown: shippingKnown,
+			Condition:     location,
+			URL:           strings.Split(item.URL, "?")[0],
+			Date:          crawlDate,
+			Duration:      0,
+			AcceptsOffers: true,
+		}
+		if !rules.keep(listing) {
+			continue
+		}
+		if overLimit(desiredPrice, price, shipping) {
+			rules.reject(listing, types.RejectPrice, desiredPrice.String())
+			continue
+		}
+		if !ships && location != "" && !rules.allowed(listing.URL) {
+			nearby, distStr, err := ValidateDistance(location, homeLat, homeLong, maxDistance, maxDriveTime)
+			if err != nil {
+				slog.Info("skipping url, could not get distance", slog.String("url", listing.URL), slog.Any("Error", err))
+				continue
+			}
+			if !nearby {
+				rules.reject(listing, types.RejectDistance, distStr+", "+distanceLimit(maxDistance, maxDriveTime))
+				continue
+			}
+			listing.Condition += " " + distStr
+		}
+		retArr = append(retArr, listing)
+	}
+	return retArr, nil
+}
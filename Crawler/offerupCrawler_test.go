@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+func TestParseOfferUpCard(t *testing.T) {
+	for _, tt := range []struct {
+		text                                       string
+		wantPrice, wantTitle, wantAt, wantShipping string
+	}{
+		{"$120\nSteam Deck\nTorrance, CA", "$120", "Steam Deck", "Torrance, CA", ""},
+		{"$150\nShips nationwide\nSteam Deck 256GB\nTorrance, CA", "$150", "Steam Deck 256GB", "Torrance, CA", "Ships nationwide"},
+		{"  $95 \n\nFree shipping\nSwitch Lite\n", "$95", "Switch Lite", "", "Free shipping"},
+		{"Steam Deck\nLong Beach, CA", "", "Steam Deck", "Long Beach, CA", ""},
+	} {
+		price, title, location, shipping := parseOfferUpCard(tt.text)
+		if price != tt.wantPrice || title != tt.wantTitle || location != tt.wantAt || shipping != tt.wantShipping {
+			t.Errorf("parseOfferUpCard(%q) = %q, %q, %q, %q, want %q, %q, %q, %q", tt.text,
+				price, title, location, shipping, tt.wantPrice, tt.wantTitle, tt.wantAt, tt.wantShipping)
+		}
+	}
+}
+
+func TestCrawlOfferUp(t *testing.T) {
+	replayFixtures(t)
+	// no routing, distances are straight lines from torrance
+	useGeoProvider(t, staticGeoProvider{table: map[string]Location{
+		"torrance, ca":   {Lat: 33.8358, Lon: -118.3406},
+		"long beach, ca": {Lat: 33.7701, Lon: -118.1937},
+		"san diego, ca":  {Lat: 32.7157, Lon: -117.1611},
+	}})
+	price := usd(40000)
+	recordSnapshot(t, "offerup", OfferUpURLGenerator("steam deck", price), "offerupSearch.html")
+	rules, rejected := testRules("steam deck", "Tech", types.TitleFilter{})
+
+	listings, err := CrawlOfferUp(t.Context(), "steam deck", price, rules, 33.8358, -118.3406, 25, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://offerup.com/item/detail/1a2b3c4d-0001", "https://offerup.com/item/detail/1a2b3c4d-0002"}
+	if got := listingURLs(listings); !slices.Equal(got, want) {
+		t.Fatalf("got listings %v, want %v", got, want)
+	}
+	if l := listings[0]; l.Condition != "Torrance, CA" || !l.Ships || l.ShippingKnown {
+		t.Errorf("got condition %q ships %v shipping known %v for a post shipped at an unknown cost",
+			l.Condition, l.Ships, l.ShippingKnown)
+	}
+	if listings[1].Ships {
+		t.Error("local post marked as shipped")
+	}
+	if !strings.HasPrefix(listings[1].Condition, "Long Beach, CA ") || !strings.Contains(listings[1].Condition, "straight line") {
+		t.Errorf("got condition %q for a local post", listings[1].Condition)
+	}
+
+	// the post that can't be geocoded is skipped without a rejection, the
+	// last one is only over the price with its shipping
+	var reasons []types.RejectReason
+	for _, r := range *rejected {
+		reasons = append(reasons, r.Reason)
+	}
+	if !slices.Equal(reasons, []types.RejectReason{types.RejectDistance, types.RejectPrice, types.RejectPrice}) {
+		t.Errorf("got rejections %v", reasons)
+	}
+}
+
+func TestCrawlOfferUpNoResults(t *testing.T) {
+	replayFixtures(t)
+	price := usd(40000)
+	recordSnapshot(t, "offerup", OfferUpURLGenerator("steam deck", price), "offerupNoResults.html")
+	rules, _ := testRules("steam deck", "Tech", types.TitleFilter{})
+
+	listings, err := CrawlOfferUp(t.Context(), "steam deck", price, rules, 33.8358, -118.3406, 25, 0, true)
+	if err != nil || listings != nil {
+		t.Fatalf("got %v, %v, want an empty search to be nil, nil", listingURLs(listings), err)
+	}
+}
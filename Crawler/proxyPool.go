@@ -0,0 +1,344 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+const (
+	// proxy everything went through before the pool was configurable
+	defaultProxyURL = "http://gluetun:8888"
+	// a proxy is taken out of rotation after this many failures in a row
+	// and put back once a health check passes
+	maxProxyFailures     = 3
+	proxyCheckInterval   = 10 * time.Minute
+	defaultProxyCheckURL = "https://www.google.com/generate_204"
+)
+
+type Proxy struct {
+	URL *url.URL
+
+	mu sync.Mutex
+	// failures in a row, reset on the first success
+	failures       int
+	totalFailures  int
+	totalSuccesses int
+	healthy        bool
+	lastCheck      time.Time
+}
+
+// copy of the proxy counters that is safe to read
+type ProxyStatus struct {
+	URL            string
+	Healthy        bool
+	Failures       int
+	TotalFailures  int
+	TotalSuccesses int
+	LastCheck      time.Time
+}
+
+var (
+	proxies     []*Proxy
+	proxiesOnce sync.Once
+	// next proxy index per domain so every site rotates through the pool
+	// on its own
+	domainProxyIndex   = map[string]int{}
+	domainProxyIndexMu sync.Mutex
+)
+
+// PROXY_URLS is a comma separated list of http, https or socks5 proxies,
+// defaults to the gluetun container and "none" disables proxies
+func loadProxyPool() {
+	list := os.Getenv("PROXY_URLS")
+	if list == "" {
+		list = defaultProxyURL
+	}
+	proxies = parseProxyURLs(list)
+	names := make([]string, len(proxies))
+	for i, p := range proxies {
+		names[i] = p.URL.Redacted()
+	}
+	slog.Info("proxy pool loaded", slog.Any("Proxies", names))
+}
+
+func parseProxyURLs(list string) []*Proxy {
+	var pool []*Proxy
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || strings.EqualFold(raw, "none") {
+			continue
+		}
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			slog.Error("skipping invalid proxy url", slog.String("URL", raw), slog.Any("Error", err))
+			continue
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			slog.Error("skipping proxy with unsupported scheme", slog.String("URL", u.Redacted()))
+			continue
+		}
+		pool = append(pool, &Proxy{URL: u, healthy: true})
+	}
+	return pool
+}
+
+func proxyPool() []*Proxy {
+	proxiesOnce.Do(loadProxyPool)
+	return proxies
+}
+
+// hostnames share a rotation with and without www
+func proxyDomain(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// round robins the healthy proxies for the domain, nil when there are none
+func PickProxy(host string) *Proxy {
+	pool := proxyPool()
+	if len(pool) == 0 {
+		return nil
+	}
+	domain := proxyDomain(host)
+	domainProxyIndexMu.Lock()
+	start := domainProxyIndex[domain]
+	domainProxyIndex[domain] = start + 1
+	domainProxyIndexMu.Unlock()
+	for i := range pool {
+		p := pool[(start+i)%len(pool)]
+		if p.Healthy() {
+			return p
+		}
+	}
+	return nil
+}
+
+func HasHealthyProxy() bool {
+	for _, p := range proxyPool() {
+		if p.Healthy() {
+			return true
+		}
+	}
+	return false
+}
+
+// the crawl ladder is proxy, no proxy, then chromedp. a proxied rung with
+// no healthy proxy would just be a direct request, so it is skipped
+func useProxy(proxy bool) bool {
+	// replayed fixtures are the same with and without a proxy
+	if fixtureMode() == fixtureReplay {
+		return false
+	}
+	if proxy && !HasHealthyProxy() {
+		slog.Warn("no healthy proxy in pool, crawling without proxy")
+		return false
+	}
+	return proxy
+}
+
+func (p *Proxy) Healthy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.healthy
+}
+
+// records the result of a request that went through the proxy, safe to
+// call on a nil proxy for requests that went direct
+func (p *Proxy) Report(err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		p.failures = 0
+		p.totalSuccesses++
+		return
+	}
+	p.failures++
+	p.totalFailures++
+	if p.healthy && p.failures >= maxProxyFailures {
+		p.healthy = false
+		slog.Warn("proxy taken out of rotation", slog.String("Proxy", p.URL.Redacted()),
+			slog.Int("Failures", p.failures), slog.Any("Error", err))
+	}
+}
+
+func (p *Proxy) Status() ProxyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ProxyStatus{
+		URL:            p.URL.Redacted(),
+		Healthy:        p.healthy,
+		Failures:       p.failures,
+		TotalFailures:  p.totalFailures,
+		TotalSuccesses: p.totalSuccesses,
+		LastCheck:      p.lastCheck,
+	}
+}
+
+func ProxyStatuses() []ProxyStatus {
+	var statuses []ProxyStatus
+	for _, p := range proxyPool() {
+		statuses = append(statuses, p.Status())
+	}
+	return statuses
+}
+
+// sets the collector up to go through the pool. each collector sticks to
+// one proxy per host so the result can be reported back to it, the http
+// request colly hands the proxy func is a copy so it can't carry it.
+// requests go direct when every proxy is down
+func attachProxyPool(c *colly.Collector) {
+	var mu sync.Mutex
+	picked := map[string]*Proxy{}
+	c.SetProxyFunc(func(r *http.Request) (*url.URL, error) {
+		host := r.URL.Hostname()
+		mu.Lock()
+		defer mu.Unlock()
+		p, ok := picked[host]
+		if !ok || !p.Healthy() {
+			p = PickProxy(host)
+			picked[host] = p
+		}
+		if p == nil {
+			return nil, nil
+		}
+		return p.URL, nil
+	})
+	report := func(r *colly.Response, err error) {
+		mu.Lock()
+		p := picked[r.Request.URL.Hostname()]
+		mu.Unlock()
+		// only connection errors and blocks count against the proxy, a 404
+		// is the same through every proxy
+		switch {
+		case p == nil:
+		case err == nil:
+			p.Report(nil)
+		case r.StatusCode == 0, r.StatusCode == http.StatusForbidden,
+			r.StatusCode == http.StatusProxyAuthRequired,
+			r.StatusCode == http.StatusTooManyRequests,
+			r.StatusCode >= 500:
+			p.Report(err)
+		}
+	}
+	c.OnResponse(func(r *colly.Response) {
+		report(r, nil)
+	})
+	c.OnError(report)
+}
+
+// leases a browser tab going through a proxy from the pool. chrome does
+// not take proxy credentials on the command line so they are dropped,
+// proxies that need them only work for colly. when no tab can be opened
+// through the proxy, like its browser not starting, the lease goes direct.
+// the returned proxy is nil when the tab goes direct
+func leaseProxyTab(ctx context.Context, uri string, proxy bool, timeout time.Duration) (context.Context, context.CancelFunc, *Proxy, error) {
+	if proxy {
+		host := uri
+		if u, err := url.Parse(uri); err == nil {
+			host = u.Hostname()
+		}
+		if p := PickProxy(host); p != nil {
+			server := fmt.Sprintf("%s://%s", p.URL.Scheme, p.URL.Host)
+			tabCtx, cancel, err := leaseTab(ctx, timeout, server)
+			if err == nil {
+				return tabCtx, cancel, p, nil
+			}
+			slog.Error("could not lease proxy browser tab, trying without proxy",
+				slog.String("URL", uri), slog.String("Proxy", p.URL.Redacted()), slog.Any("Error", err))
+			if ctx.Err() != nil {
+				return nil, nil, nil, err
+			}
+		} else {
+			slog.Warn("no healthy proxy in pool, chromedp crawling without proxy", slog.String("URL", uri))
+		}
+	}
+	tabCtx, cancel, err := leaseTab(ctx, timeout, "")
+	if err != nil {
+		slog.Error("could not lease browser tab", slog.String("URL", uri), slog.Any("Error", err))
+	}
+	return tabCtx, cancel, nil, err
+}
+
+// requests PROXY_CHECK_URL through every proxy and puts the ones that
+// answer back into rotation
+func CheckProxies(ctx context.Context) {
+	checkURL := os.Getenv("PROXY_CHECK_URL")
+	if checkURL == "" {
+		checkURL = defaultProxyCheckURL
+	}
+	var wg sync.WaitGroup
+	for _, p := range proxyPool() {
+		wg.Go(func() {
+			err := checkProxy(ctx, p, checkURL)
+			p.mu.Lock()
+			p.lastCheck = time.Now()
+			wasHealthy := p.healthy
+			p.healthy = err == nil
+			if err == nil {
+				p.failures = 0
+			}
+			p.mu.Unlock()
+			if err != nil {
+				slog.Warn("proxy health check failed", slog.String("Proxy", p.URL.Redacted()), slog.Any("Error", err))
+			} else if !wasHealthy {
+				slog.Info("proxy back in rotation", slog.String("Proxy", p.URL.Redacted()))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func checkProxy(ctx context.Context, p *Proxy, checkURL string) error {
+	client := &http.Client{
+		Timeout:   20 * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyURL(p.URL)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// runs until ctx is cancelled, replaces restarting the proxy container on
+// a timer
+func StartProxyHealthChecks(ctx context.Context) {
+	if len(proxyPool()) == 0 {
+		return
+	}
+	ticker := time.NewTicker(proxyCheckInterval)
+	defer ticker.Stop()
+	CheckProxies(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			CheckProxies(ctx)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	types "priceTracker/Types"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// certified used and refurbished stores, they all render their search
+// results server side so one colly crawler driven by selectors covers them
+type refurbishedSite struct {
+	name   string
+	domain string // circuit breaker key, see SecondHandSource.Domain
+	// shown as the listing condition
+	label string
+	// fmt string taking the escaped query
+	searchURL string
+	card      string
+	title     string
+	price     string
+	link      string
+	// prepended to relative links
+	base string
+	// lowercase text of the page shown when the search matched nothing
+	noResults string
+}
+
+var refurbishedSites = []refurbishedSite{
+	{
+		name:      "swappa",
+		domain:    "swappa.com",
+		label:     "Swappa",
+		searchURL: "https://swappa.com/search?q=%s",
+		card:      "div.listing_row",
+		title:     ".headline",
+		price:     ".price",
+		link:      "a[href*='/listing/']",
+		base:      "https://swappa.com",
+		noResults: "no listings found",
+	},
+	{
+		name:      "backmarket",
+		domain:    "backmarket.com",
+		label:     "Back Market",
+		searchURL: "https://www.backmarket.com/en-us/search?q=%s",
+		card:      "div[data-qa='productCard']",
+		title:     "h2",
+		price:     "[data-qa='productCardPrice']",
+		link:      "a[href*='/p/']",
+		base:      "https://www.backmarket.com",
+		noResults: "no results",
+	},
+	{
+		name:      "amazonrenewed",
+		domain:    "amazon.com/renewed", // a blocked search should not pause the amazon trackers
+		label:     "Amazon Renewed",
+		searchURL: "https://www.amazon.com/s?k=%s&rh=n%%3A12653393011",
+		card:      "div[data-component-type='s-search-result']",
+		title:     "h2 span",
+		price:     "span.a-price > span.a-offscreen",
+		link:      "a[href*='/dp/']",
+		base:      "https://www.amazon.com",
+		noResults: "no results for",
+	},
+}
+
+type refurbishedSource struct {
+	site refurbishedSite
+}
+
+func init() {
+	for _, site := range refurbishedSites {
+		RegisterSecondHandSource(refurbishedSource{site: site})
+	}
+}
+
+func (s refurbishedSource) Name() string { return s.site.name }
+
+func (s refurbishedSource) Domain() string { return s.site.domain }
+
+// certified used is only a thing for electronics
+func (refurbishedSource) ItemTypes() []string { return []string{"Tech"} }
+
+func (s refurbishedSource) Search(ctx context.Context, query SecondHandQuery) ([]*types.EbayListing, error) {
+	return CrawlRefurbished(s.site, query.Name, query.Price, query.titleRules(s.site.name), true)
+}
+
+// listings come back in the refurbished tier, none of these take offers
+func CrawlRefurbished(site refurbishedSite, Name string, desiredPrice types.Money, rules *TitleRules, Proxy bool) ([]*types.EbayListing, error) {
+	uri := fmt.Sprintf(site.searchURL, url.QueryEscape(Name))
+	Proxy = useProxy(Proxy)
+	slog.Info("crawling refurbished URL", slog.String("Source", site.name), slog.String("URL", uri), slog.Bool("proxy", Proxy))
+	crawlDate := time.Now()
+	var retArr []*types.EbayListing
+	visited := false
+	noResults := false
+	c := initCrawler(Proxy)
+	c.OnHTML("body", func(e *colly.HTMLElement) {
+		noResults = strings.Contains(strings.ToLower(e.Text), site.noResults)
+	})
+	c.OnHTML(site.card, func(e *colly.HTMLElement) {
+		visited = true
+		title := e.ChildText(site.title)
+		link := e.ChildAttr(site.link, "href")
+		if strings.HasPrefix(link, "/") {
+			link = site.base + link
+		}
+		// some cards show a price range, the first one is the lowest
+		price, err := formatPrice(e.ChildText(site.price))
+		if price.IsZero() || err != nil || link == "" {
+			slog.Debug("skipping refurbished card", slog.String("Source", site.name), slog.String("Title", title))
+			return
+		}
+		listing := &types.EbayListing{
+			ItemName:  Name,
+			Title:     title,
+			Price:     price,
+			Condition: site.label,
+			URL:       strings.Split(link, "?")[0],
+			Date:      crawlDate,
+			Duration:  0,
+			Tier:      types.TierRefurbished,
+		}
+		if !rules.keep(listing) {
+			return
+		}
+		if overLimit(desiredPrice, price) {
+			rules.reject(listing, types.RejectPrice, desiredPrice.String())
+			return
+		}
+		slog.Info("listing", slog.Any("refurbished listing information", listing))
+		retArr = append(retArr, listing)
+	})
+	err := c.Visit(uri)
+	c.Wait()
+	// nothing matched the search, not a failure of the site
+	if err == nil && !visited && noResults {
+		slog.Info("no refurbished listings found", slog.String("Source", site.name), slog.String("URL", uri))
+		return nil, nil
+	}
+	if err != nil || !visited {
+		if Proxy {
+			slog.Warn("refurbished proxy failed, redoing request without proxy",
+				slog.String("Source", site.name), slog.Any("Error", err))
+			return CrawlRefurbished(site, Name, desiredPrice, rules, false)
+		}
+		if err == nil {
+			err = fmt.Errorf("%s results not found, might have been blocked", site.label)
+		}
+		return retArr, errors.Join(fmt.Errorf("Error in %s:", site.name), blocked(err))
+	}
+	return retArr, nil
+}
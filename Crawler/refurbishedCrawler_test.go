@@ -0,0 +1,69 @@
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+func TestCrawlRefurbished(t *testing.T) {
+	want := map[string]string{
+		"swappa":        "https://swappa.com/listing/view/LABC1234",
+		"backmarket":    "https://www.backmarket.com/en-us/p/iphone-13-128-gb-blue-unlocked/2e1f3c45",
+		"amazonrenewed": "https://www.amazon.com/Apple-iPhone-13-128GB-Midnight/dp/B09LNW3CY2/ref=sr_1_1",
+	}
+	for _, site := range refurbishedSites {
+		t.Run(site.name, func(t *testing.T) {
+			replayFixtures(t)
+			price := usd(50000)
+			recordPage(t, fmt.Sprintf(site.searchURL, url.QueryEscape("iphone 13")), site.name+"Search.html")
+			rules, rejected := testRules("iphone 13", "Tech", types.TitleFilter{})
+
+			listings, err := CrawlRefurbished(site, "iphone 13", price, rules, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := listingURLs(listings); !slices.Equal(got, []string{want[site.name]}) {
+				t.Fatalf("got listings %v, want %s", got, want[site.name])
+			}
+			if l := listings[0]; l.Tier != types.TierRefurbished || l.Condition != site.label || l.AcceptsOffers {
+				t.Errorf("got tier %q condition %q offers %v", l.Tier, l.Condition, l.AcceptsOffers)
+			}
+			if len(*rejected) != 1 || (*rejected)[0].Reason != types.RejectPrice {
+				t.Errorf("got rejections %+v, want the pro model over the price", *rejected)
+			}
+		})
+	}
+}
+
+// a search that matched nothing is not a failure, a page without results
+// or the no results text is
+func TestCrawlRefurbishedEmpty(t *testing.T) {
+	for _, site := range refurbishedSites {
+		for _, tt := range []struct {
+			page    string
+			wantErr bool
+		}{
+			{site.name + "NoResults.html", false},
+			{"blocked.html", true},
+		} {
+			t.Run(site.name+"/"+tt.page, func(t *testing.T) {
+				replayFixtures(t)
+				price := usd(50000)
+				recordPage(t, fmt.Sprintf(site.searchURL, url.QueryEscape("iphone 13")), tt.page)
+				rules, _ := testRules("iphone 13", "Tech", types.TitleFilter{})
+
+				listings, err := CrawlRefurbished(site, "iphone 13", price, rules, true)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+				}
+				if len(listings) != 0 {
+					t.Errorf("got listings %v", listingURLs(listings))
+				}
+			})
+		}
+	}
+}
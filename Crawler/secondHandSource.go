@@ -0,0 +1,206 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	types "priceTracker/Types"
+)
+
+// SecondHandQuery holds everything a used-market source needs to run
+// a search for a single item
+type SecondHandQuery struct {
+	Name string
+	// highest landed price a listing can have to be returned
+	Price        types.Money
+	ItemType     string
+	Lat          float64
+	Long         float64
+	Distance     int
+	LocationCode string
+	// minutes of driving, 0 means only Distance is checked
+	MaxDriveTime int
+	// channel sales tax as a fraction, sources return prices before tax
+	TaxRate float64
+	// title relevance rules of the item, see NewTitleRules
+	Filter types.TitleFilter
+	// called for every listing a source drops, can be nil
+	OnReject func(types.RejectedListing)
+	// called with the name of every source that was searched without an
+	// error, can be nil
+	OnCrawled func(source string)
+	// sources that have auctions return them as well
+	Auctions bool
+}
+
+// title rules for a source searching for the query
+func (q SecondHandQuery) titleRules(source string) *TitleRules {
+	rules := NewTitleRules(q.Name, q.ItemType, q.Filter)
+	rules.source = source
+	rules.onReject = q.OnReject
+	return rules
+}
+
+// SecondHandSource is a used marketplace that can be searched for listings,
+// sources register themselves in init() so GetSecondHandListings does not
+// need to know about them
+type SecondHandSource interface {
+	// unique lowercase name, used to enable/disable the source per channel
+	Name() string
+	// site the source crawls, failures count towards its circuit breaker.
+	// a source sharing a site with trackers can use a path to get a
+	// breaker of its own
+	Domain() string
+	// item types this source should be used for, empty means all types
+	ItemTypes() []string
+	Search(ctx context.Context, query SecondHandQuery) ([]*types.EbayListing, error)
+}
+
+// kept as a slice so sources always run in registration order
+var secondHandSources []SecondHandSource
+
+func RegisterSecondHandSource(source SecondHandSource) {
+	for _, s := range secondHandSources {
+		if s.Name() == source.Name() {
+			panic("second hand source registered twice: " + source.Name())
+		}
+	}
+	secondHandSources = append(secondHandSources, source)
+}
+
+// returns the names of every registered source
+func SecondHandSourceNames() []string {
+	names := make([]string, 0, len(secondHandSources))
+	for _, s := range secondHandSources {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+func GetSecondHandSource(Name string) (SecondHandSource, bool) {
+	for _, s := range secondHandSources {
+		if s.Name() == Name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// checks wether the source should be used for the item type
+func SupportsItemType(source SecondHandSource, itemType string) bool {
+	supported := source.ItemTypes()
+	return len(supported) == 0 || slices.Contains(supported, itemType)
+}
+
+// parses a comma separated list of source names, validating each one
+// against the registry
+func ParseSourceList(list string) ([]string, error) {
+	var ret []string
+	for name := range strings.SplitSeq(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := GetSecondHandSource(name); !ok {
+			return nil, fmt.Errorf("unknown second hand source %s, available sources are %s",
+				name, strings.Join(SecondHandSourceNames(), ", "))
+		}
+		if !slices.Contains(ret, name) {
+			ret = append(ret, name)
+		}
+	}
+	return ret, nil
+}
+
+// runs every enabled source that supports the item type, an empty enabled
+// list means all registered sources are used. listings of the sources that
+// worked are returned along with the joined errors of the ones that failed
+func GetSecondHandListings(ctx context.Context, query SecondHandQuery, enabled []string) ([]*types.EbayListing, error) {
+	if query.ItemType == "Clothes" {
+		query.Price = query.Price.Mul(0.5)
+	}
+	// the sources are all us sites, they get the limit in dollars and the
+	// listings are converted back to the channel currency
+	limit := query.Price
+	if usd, err := ConvertMoney(query.Price, types.DefaultCurrency); err == nil {
+		query.Price = usd
+	} else {
+		slog.Error("could not convert second hand price limit", slog.Any("Error", err))
+	}
+
+	retArr := []*types.EbayListing{}
+	var errs []error
+	for _, source := range secondHandSources {
+		if len(enabled) != 0 && !slices.Contains(enabled, source.Name()) {
+			continue
+		}
+		if !SupportsItemType(source, query.ItemType) {
+			continue
+		}
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		// an open breaker was already alerted on when it tripped
+		if err := breakerAllow(source.Domain()); err != nil {
+			slog.Warn("skipping second hand source", slog.String("Source", source.Name()), slog.Any("Error", err))
+			continue
+		}
+		listings, err := source.Search(ctx, query)
+		breakerRecord(source.Domain(), err)
+		if err != nil {
+			slog.Error("error from getting second hand listing",
+				slog.String("Source", source.Name()),
+				slog.Any("Error", err),
+			)
+			errs = append(errs, err)
+		} else if query.OnCrawled != nil {
+			query.OnCrawled(source.Name())
+		}
+		// sources filter on the raw price, drop what tax pushed over the limit.
+		// shipping can be quoted in another currency than the item
+		for _, listing := range listings {
+			listing.Source = source.Name()
+			listing.RawPrice = listing.Price
+			price, err := ConvertMoney(listing.Price, limit.Currency)
+			shipping := listing.Shipping
+			if err == nil {
+				shipping, err = ConvertMoney(shipping, limit.Currency)
+			}
+			if err != nil {
+				slog.Error("could not convert listing price", slog.String("URL", listing.URL), slog.Any("Error", err))
+				continue
+			}
+			listing.Price = price.Landed(query.TaxRate, shipping)
+			if listing.Price.Cmp(limit) >= 0 {
+				if query.OnReject != nil {
+					query.OnReject(newRejection(source.Name(), listing, types.RejectPrice, limit.String()))
+				}
+				continue
+			}
+			retArr = append(retArr, listing)
+		}
+	}
+	return retArr, errors.Join(errs...)
+}
+
+// reads a shipping badge like "Free shipping" or "+$8.99 shipping", known
+// is false when it only says the item ships. only amounts with a $ are
+// read so "ships in 2 days" isn't taken as a cost
+func parseShippingText(text string) (cost types.Money, known bool) {
+	if strings.Contains(strings.ToLower(text), "free") {
+		return types.Money{}, true
+	}
+	if !strings.Contains(text, "$") {
+		return types.Money{}, false
+	}
+	cost, err := formatPrice(text)
+	if err != nil {
+		return types.Money{}, false
+	}
+	return cost, true
+}
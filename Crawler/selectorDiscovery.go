@@ -0,0 +1,242 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/chromedp"
+	"github.com/gocolly/colly/v2"
+)
+
+type SelectorCandidate struct {
+	Selector string
+	// text the selector matched on the page
+	Text  string
+	Score int
+}
+
+// attributes that are usually kept stable by sites since tests
+// and analytics depend on them
+var stableAttributes = []string{
+	"itemprop", "data-testid", "data-test", "data-test-id",
+	"data-automation-id", "data-qa", "data-price-type", "data-a-color",
+}
+
+var (
+	// css modules style classes like styles_price__H8qdh, keeps the prefix
+	cssModuleClass = regexp.MustCompile(`^([A-Za-z][\w-]*?__?)[A-Za-z0-9]{5,}$`)
+	// plain readable class and id names like price-current or a-price-whole
+	readableName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z-_]*[0-9]{0,2}$`)
+)
+
+// fetches the page and returns the selectors, best first, whose text
+// parses to the price the user sees on the page
+func DiscoverSelectors(ctx context.Context, uri string, price int, limit int) ([]SelectorCandidate, error) {
+	html, err := fetchPageHTML(uri, true)
+	var candidates []SelectorCandidate
+	if err == nil {
+		candidates, err = rankSelectors(html, price, limit)
+	}
+	if err != nil || len(candidates) == 0 {
+		slog.Warn("colly selector discovery failed, triggering chromedp",
+			slog.String("URI", uri), slog.Any("Error", err))
+		html, err = chromedpPageHTML(ctx, uri, true)
+		if err != nil {
+			return nil, err
+		}
+		candidates, err = rankSelectors(html, price, limit)
+	}
+	if err == nil && len(candidates) == 0 {
+		err = fmt.Errorf("no element with price %d found on page", price)
+	}
+	return candidates, err
+}
+
+func fetchPageHTML(uri string, proxy bool) (string, error) {
+	proxy = useProxy(proxy)
+	c := initCrawler(proxy)
+	var html string
+	c.OnResponse(func(r *colly.Response) {
+		html = string(r.Body)
+	})
+	err := c.Visit(uri)
+	c.Wait()
+	if err != nil || html == "" {
+		if proxy {
+			return fetchPageHTML(uri, false)
+		}
+		if err == nil {
+			err = errors.New("empty page returned")
+		}
+		return "", err
+	}
+	return html, nil
+}
+
+func chromedpPageHTML(ctx context.Context, uri string, proxy bool) (string, error) {
+	var html string
+	var pooled *Proxy
+	var leaseErr error
+	err := func() error {
+		var tabCtx context.Context
+		var cancel context.CancelFunc
+		tabCtx, cancel, pooled, leaseErr = leaseProxyTab(ctx, uri, proxy, 90*time.Second)
+		if leaseErr != nil {
+			return leaseErr
+		}
+		defer cancel()
+		err := chromedpSnapshot(tabCtx, "page", uri, chromedp.Tasks{
+			chromedp.Navigate(uri),
+			StealthActions(),
+			chromedp.Sleep(15 * time.Second),
+		}, chromedp.OuterHTML("html", &html))
+		pooled.Report(err)
+		return err
+	}()
+	if leaseErr != nil {
+		return "", leaseErr
+	}
+	proxy = pooled != nil
+	if err != nil && proxy {
+		return chromedpPageHTML(ctx, uri, false)
+	}
+	return html, err
+}
+
+func rankSelectors(html string, price int, limit int) ([]SelectorCandidate, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var candidates []SelectorCandidate
+	doc.Find("body *").Each(func(i int, s *goquery.Selection) {
+		if s.Is("script, style, noscript, svg") {
+			return
+		}
+		text := strings.TrimSpace(s.Text())
+		if len(text) == 0 || len(text) > 30 || !textMatchesPrice(text, price) {
+			return
+		}
+		// wrappers around the price element are less precise than the element itself
+		wrapper := s.Find("*").FilterFunction(func(i int, child *goquery.Selection) bool {
+			return textMatchesPrice(strings.TrimSpace(child.Text()), price)
+		}).Length() != 0
+		for _, candidate := range selectorsFor(s) {
+			if seen[candidate.Selector] {
+				continue
+			}
+			seen[candidate.Selector] = true
+			// the selector has to point at the price when used by the crawler
+			matches := doc.Find(candidate.Selector)
+			if matches.Length() == 0 || !textMatchesPrice(matches.First().Text(), price) {
+				continue
+			}
+			if matches.Length() == 1 {
+				candidate.Score += 20
+			} else {
+				candidate.Score -= matches.Length()
+			}
+			if wrapper {
+				candidate.Score -= 30
+			}
+			if strings.Contains(strings.ToLower(candidate.Selector), "price") {
+				candidate.Score += 10
+			}
+			if strings.ContainsAny(text, "$€£¥") {
+				candidate.Score += 5
+			}
+			candidate.Text = text
+			candidates = append(candidates, candidate)
+		}
+	})
+	slices.SortStableFunc(candidates, func(a, b SelectorCandidate) int {
+		return b.Score - a.Score
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
+
+// the price is entered in whole units, so any cents on the page are ignored
+func textMatchesPrice(text string, price int) bool {
+	p, err := formatPrice(text)
+	return err == nil && int(p.Major()) == price
+}
+
+// builds selectors for an element from its most stable identifiers,
+// falling back to the closest identifiable ancestor for context
+func selectorsFor(s *goquery.Selection) []SelectorCandidate {
+	own := elementSelectors(s)
+	var ret []SelectorCandidate
+	ret = append(ret, own...)
+
+	var ancestor []SelectorCandidate
+	s.Parents().EachWithBreak(func(i int, p *goquery.Selection) bool {
+		if p.Is("body, html") {
+			return false
+		}
+		ancestor = elementSelectors(p)
+		return len(ancestor) == 0
+	})
+	if len(ancestor) == 0 {
+		return ret
+	}
+	tag := goquery.NodeName(s)
+	parent := ancestor[0]
+	if len(own) == 0 {
+		ret = append(ret, SelectorCandidate{
+			Selector: parent.Selector + " " + tag,
+			Score:    parent.Score - 20,
+		})
+	}
+	for _, o := range own {
+		// ids are unique already, the ancestor adds nothing
+		if strings.HasPrefix(o.Selector, "#") {
+			continue
+		}
+		ret = append(ret, SelectorCandidate{
+			Selector: parent.Selector + " " + o.Selector,
+			Score:    (parent.Score+o.Score)/2 + 5,
+		})
+	}
+	return ret
+}
+
+// selectors that identify the element on its own, scored by how stable
+// the identifier usually is
+func elementSelectors(s *goquery.Selection) []SelectorCandidate {
+	var ret []SelectorCandidate
+	tag := goquery.NodeName(s)
+	if id, ok := s.Attr("id"); ok && readableName.MatchString(id) {
+		ret = append(ret, SelectorCandidate{Selector: "#" + id, Score: 100})
+	}
+	for _, attr := range stableAttributes {
+		if val, ok := s.Attr(attr); ok && val != "" && !strings.ContainsAny(val, `'"`) {
+			ret = append(ret, SelectorCandidate{
+				Selector: fmt.Sprintf("%s[%s='%s']", tag, attr, val),
+				Score:    80,
+			})
+		}
+	}
+	class, _ := s.Attr("class")
+	for _, c := range strings.Fields(class) {
+		if readableName.MatchString(c) {
+			ret = append(ret, SelectorCandidate{Selector: tag + "." + c, Score: 60})
+		} else if m := cssModuleClass.FindStringSubmatch(c); m != nil {
+			ret = append(ret, SelectorCandidate{
+				Selector: fmt.Sprintf("%s[class*='%s']", tag, m[1]),
+				Score:    50,
+			})
+		}
+	}
+	return ret
+}
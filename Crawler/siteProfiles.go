@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// describes how to crawl a retailer, loaded from SITE_PROFILES_PATH or
+// the siteProfiles.json bundled with the binary
+type SiteProfile struct {
+	Name string `json:"name"`
+	// host names the profile applies to, subdomains match as well
+	Domains []string `json:"domains"`
+	// tried in order, the first one found on the page is used
+	PriceSelectors []string `json:"priceSelectors"`
+	ImageSelector  string   `json:"imageSelector"`
+	// attribute holding the image url, defaults to src
+	ImageAttribute string `json:"imageAttribute"`
+	// skips colly and goes straight to chromedp
+	RequiresChromedp bool `json:"requiresChromedp"`
+	// elements clicked in chromedp before reading the page, like
+	// amazon's continue shopping interstitial
+	PreClickSelectors []string `json:"preClickSelectors"`
+	// elements that only exist on the page for that stock status
+	OutOfStockSelectors     []string `json:"outOfStockSelectors"`
+	ThirdPartyOnlySelectors []string `json:"thirdPartyOnlySelectors"`
+	PreOrderSelectors       []string `json:"preOrderSelectors"`
+}
+
+//go:embed siteProfiles.json
+var defaultSiteProfiles []byte
+
+var (
+	siteProfiles     []SiteProfile
+	siteProfilesOnce sync.Once
+)
+
+// profiles are loaded lazily so the env file is read by then
+func loadSiteProfiles() {
+	data := defaultSiteProfiles
+	if path := os.Getenv("SITE_PROFILES_PATH"); path != "" {
+		file, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("could not read site profiles, using defaults",
+				slog.String("Path", path), slog.Any("Error", err))
+		} else {
+			data = file
+		}
+	}
+	if err := json.Unmarshal(data, &siteProfiles); err != nil {
+		slog.Error("could not parse site profiles, using defaults", slog.Any("Error", err))
+		json.Unmarshal(defaultSiteProfiles, &siteProfiles)
+	}
+	slog.Info("site profiles loaded", slog.Int("Profiles", len(siteProfiles)))
+}
+
+func SiteProfiles() []SiteProfile {
+	siteProfilesOnce.Do(loadSiteProfiles)
+	return siteProfiles
+}
+
+// returns the profile whose domain matches the url host
+func ProfileForURL(uri string) (SiteProfile, bool) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return SiteProfile{}, false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, profile := range SiteProfiles() {
+		for _, domain := range profile.Domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return profile, true
+			}
+		}
+	}
+	return SiteProfile{}, false
+}
+
+// the user selector goes first, followed by the profile selectors as
+// fallbacks in case the retailer reshuffled their page
+func priceSelectorsFor(uri string, querySelector string) []string {
+	selectors := []string{querySelector}
+	if profile, ok := ProfileForURL(uri); ok {
+		for _, selector := range profile.PriceSelectors {
+			if selector != querySelector {
+				selectors = append(selectors, selector)
+			}
+		}
+	}
+	return selectors
+}
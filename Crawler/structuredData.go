@@ -0,0 +1,152 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	types "priceTracker/Types"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// passing this as the query selector makes the crawler read the price from
+// structured data on the page instead of a hand written selector
+const AutoSelector = "auto"
+
+// strategies that can be recorded for a tracker
+const (
+	StrategyCSS       = "css"
+	StrategyJSONLD    = "json-ld"
+	StrategyMicrodata = "microdata"
+	StrategyMeta      = "og:price"
+)
+
+// tries json-ld, then microdata, then open graph price meta tags and
+// returns the first price found along with the strategy that found it
+// currency is used for prices that do not name one
+func extractStructuredPrice(doc *goquery.Selection, currency string) (types.Money, string, error) {
+	var errs []error
+
+	// <------------------ json-ld Product/Offer blocks ------------>
+	var jsonLDPrice string
+	doc.Find("script[type='application/ld+json']").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		var data any
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			errs = append(errs, fmt.Errorf("invalid json-ld block: %w", err))
+			return true
+		}
+		jsonLDPrice = findJSONLDPrice(data, false)
+		return jsonLDPrice == ""
+	})
+	if jsonLDPrice != "" {
+		price, err := parsePrice(jsonLDPrice, currency)
+		if err == nil && !price.IsZero() {
+			return price, StrategyJSONLD, nil
+		}
+		errs = append(errs, fmt.Errorf("could not parse json-ld price %s: %w", jsonLDPrice, err))
+	}
+
+	// <------------------ microdata itemprop=price ------------>
+	if el := doc.Find("[itemprop='price']").First(); el.Length() != 0 {
+		priceText, ok := el.Attr("content")
+		if !ok {
+			priceText = el.Text()
+		}
+		priceText += " " + doc.Find("[itemprop='priceCurrency']").First().AttrOr("content", "")
+		price, err := parsePrice(priceText, currency)
+		if err == nil && !price.IsZero() {
+			return price, StrategyMicrodata, nil
+		}
+		errs = append(errs, fmt.Errorf("could not parse microdata price %s: %w", priceText, err))
+	}
+
+	// <------------------ open graph price meta tags ------------>
+	for _, prefix := range []string{"og:price:", "product:price:"} {
+		priceText, ok := doc.Find("meta[property='" + prefix + "amount']").First().Attr("content")
+		if !ok {
+			continue
+		}
+		priceText += " " + doc.Find("meta[property='"+prefix+"currency']").First().AttrOr("content", "")
+		price, err := parsePrice(priceText, currency)
+		if err == nil && !price.IsZero() {
+			return price, StrategyMeta, nil
+		}
+		errs = append(errs, fmt.Errorf("could not parse meta price %s: %w", priceText, err))
+	}
+
+	slog.Warn("no structured price data found", slog.Any("Errors", errs))
+	return types.Money{}, "", errors.Join(errors.New("no structured price data found on page"), errors.Join(errs...))
+}
+
+// walks a decoded json-ld document looking for an Offer price, inOffer
+// is set once we are inside a Product's offers or an Offer node
+func findJSONLDPrice(data any, inOffer bool) string {
+	switch v := data.(type) {
+	case []any:
+		for _, child := range v {
+			if price := findJSONLDPrice(child, inOffer); price != "" {
+				return price
+			}
+		}
+	case map[string]any:
+		if isJSONLDType(v["@type"], "Offer", "AggregateOffer") {
+			inOffer = true
+		}
+		if inOffer {
+			for _, key := range []string{"price", "lowPrice"} {
+				if price := jsonLDValueString(v[key]); price != "" {
+					// the iso code is picked up by the money parser
+					return strings.TrimSpace(price + " " + jsonLDValueString(v["priceCurrency"]))
+				}
+			}
+			// some sites nest the price in a priceSpecification
+			if price := findJSONLDPrice(v["priceSpecification"], true); price != "" {
+				return price
+			}
+		}
+		if isJSONLDType(v["@type"], "Product", "ProductGroup") {
+			if price := findJSONLDPrice(v["offers"], true); price != "" {
+				return price
+			}
+		}
+		for _, key := range []string{"@graph", "mainEntity", "hasVariant"} {
+			if price := findJSONLDPrice(v[key], false); price != "" {
+				return price
+			}
+		}
+	}
+	return ""
+}
+
+// @type can either be a string or an array of strings
+func isJSONLDType(t any, names ...string) bool {
+	switch v := t.(type) {
+	case string:
+		for _, name := range names {
+			if strings.EqualFold(v, name) || strings.EqualFold(v, "http://schema.org/"+name) ||
+				strings.EqualFold(v, "https://schema.org/"+name) {
+				return true
+			}
+		}
+	case []any:
+		for _, child := range v {
+			if isJSONLDType(child, names...) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonLDValueString(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return fmt.Sprintf("%.2f", val)
+	}
+	return ""
+}
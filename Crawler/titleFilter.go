@@ -0,0 +1,410 @@
+package crawler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	types "priceTracker/Types"
+
+	"github.com/dlclark/regexp2"
+)
+
+// default exclusions per item type, types without a profile use
+// defaultTitleProfile. patterns are regexp2 so lookaheads work
+var (
+	defaultTitleProfile = []string{
+		`\bfor parts`,
+		`\bbroken`,
+		`\baccessories\b`,
+		`(?=.*\bonly\b)(?=.*\bbox\b)`,
+		`\bempty box`,
+		`\bcable\b`,
+		`\bdongle\b`,
+		`\bkids\b`,
+		`\bjunior\b`,
+		`read`,
+		`\bstand\b`,
+		`\badapter\b`,
+		`\bdefective`,
+		`damage`,
+		`problem`,
+	}
+	titleProfiles = map[string][]string{
+		"Tech": defaultTitleProfile,
+		// "read" and "stand" used to throw out thread counts and stand
+		// collars, the tech only words don't mean anything for clothes
+		"Clothes": {
+			`\bkids\b`,
+			`\bjunior\b`,
+			`\bdefective`,
+			`damage`,
+		},
+	}
+	compiledProfiles = map[string][]titleExclude{}
+)
+
+// exclusion with the keyword or pattern it came from, shown when a
+// listing is rejected by it
+type titleExclude struct {
+	rule string
+	re   *regexp2.Regexp
+}
+
+func init() {
+	for itemType, patterns := range titleProfiles {
+		compiledProfiles[itemType] = compileExcludes(patterns)
+	}
+	compiledProfiles[""] = compileExcludes(defaultTitleProfile)
+}
+
+func compileExcludes(patterns []string) []titleExclude {
+	var ret []titleExclude
+	for _, pattern := range patterns {
+		re, err := regexp2.Compile(pattern, 0)
+		if err != nil {
+			slog.Error("skipping invalid title exclude", slog.String("Pattern", pattern), slog.Any("Error", err))
+			continue
+		}
+		ret = append(ret, titleExclude{rule: pattern, re: re})
+	}
+	return ret
+}
+
+// default exclude patterns for the item type, shown by /filters
+func DefaultTitleExcludes(itemType string) []string {
+	if patterns, ok := titleProfiles[itemType]; ok {
+		return patterns
+	}
+	return defaultTitleProfile
+}
+
+const (
+	// share of the item name a title has to match when the item has no
+	// MinScore of its own
+	DefaultTitleMinScore = 0.8
+	// fuzzy matches below this similarity don't count at all
+	minTokenSimilarity = 0.75
+	// model numbers tell variants apart so they count double
+	numberTokenWeight = 2
+)
+
+// listings spell these both ways
+var titleSynonyms = map[string]string{
+	"grey":        "gray",
+	"colour":      "color",
+	"playstation": "ps",
+	"sz":          "size",
+	"mens":        "men",
+	"womens":      "women",
+}
+
+// title filter compiled for one item, built once per crawl instead of
+// once per listing
+type TitleRules struct {
+	include    []string
+	requireAny [][][]string
+	// keywords of each require any group as typed, for rejections
+	requireAnyRules []string
+	exclude         []titleExclude
+	minScore        float64
+	allow           []string
+	// source crawling with the rules and where its rejections go, see
+	// SecondHandQuery.OnReject
+	source   string
+	onReject func(types.RejectedListing)
+}
+
+var letterNumberBoundary = regexp.MustCompile(`([a-z])([0-9])|([0-9])([a-z])`)
+
+// lowercases, drops punctuation, splits letters from numbers so "3060ti"
+// and "3060 ti" are the same and maps synonyms
+func titleTokens(s string) []string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, s)
+	// run twice since matches can't overlap, "a1b" needs both splits
+	for range 2 {
+		s = letterNumberBoundary.ReplaceAllString(s, "$1$3 $2$4")
+	}
+	tokens := strings.Fields(s)
+	for i, token := range tokens {
+		if synonym, ok := titleSynonyms[token]; ok {
+			tokens[i] = synonym
+		}
+	}
+	return tokens
+}
+
+func normalizeTitle(title string) string {
+	replacer := strings.NewReplacer(
+		".", " ",
+		"'", " ",
+		"'", " ",
+	)
+	return strings.TrimSpace(replacer.Replace(strings.ToLower(title)))
+}
+
+// errors on regexes that don't compile so /filters can reject them before
+// they are saved
+func ValidateTitleFilter(filter types.TitleFilter) error {
+	for _, pattern := range filter.ExcludeRegexes {
+		if _, err := regexp2.Compile(pattern, 0); err != nil {
+			return fmt.Errorf("invalid exclude regex %s: %w", pattern, err)
+		}
+	}
+	if filter.MinScore < 0 || filter.MinScore > 1 {
+		return fmt.Errorf("minimum match has to be between 0 and 100%%")
+	}
+	return nil
+}
+
+func NewTitleRules(itemName string, itemType string, filter types.TitleFilter) *TitleRules {
+	rules := &TitleRules{minScore: filter.MinScore}
+	if rules.minScore == 0 {
+		rules.minScore = DefaultTitleMinScore
+	}
+	include := filter.Include
+	if len(include) == 0 {
+		include = []string{itemName}
+	}
+	for _, keyword := range include {
+		rules.include = append(rules.include, titleTokens(keyword)...)
+	}
+	for _, group := range filter.RequireAny {
+		var keywords [][]string
+		for _, keyword := range group {
+			if tokens := titleTokens(keyword); len(tokens) != 0 {
+				keywords = append(keywords, tokens)
+			}
+		}
+		if len(keywords) != 0 {
+			rules.requireAny = append(rules.requireAny, keywords)
+			rules.requireAnyRules = append(rules.requireAnyRules, strings.Join(group, ", "))
+		}
+	}
+	if !filter.IgnoreDefaults {
+		profile, ok := compiledProfiles[itemType]
+		if !ok {
+			profile = compiledProfiles[""]
+		}
+		rules.exclude = append(rules.exclude, profile...)
+	}
+	for _, word := range filter.Exclude {
+		if normalized := normalizeTitle(word); normalized != "" {
+			rules.exclude = append(rules.exclude, titleExclude{
+				rule: word,
+				re:   regexp2.MustCompile(`\b`+regexp2.Escape(normalized)+`\b`, 0),
+			})
+		}
+	}
+	rules.exclude = append(rules.exclude, compileExcludes(filter.ExcludeRegexes)...)
+	rules.allow = filter.AllowURLs
+	return rules
+}
+
+func isNumber(token string) bool {
+	return token != "" && strings.IndexFunc(token, func(r rune) bool { return !unicode.IsDigit(r) }) == -1
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// 1 for the same token, numbers and short tokens like "ti" only match
+// exactly since one character off is a different model
+func tokenSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	if isNumber(a) || isNumber(b) || len(a) < 4 || len(b) < 4 {
+		return 0
+	}
+	ra, rb := []rune(a), []rune(b)
+	sim := 1 - float64(levenshtein(ra, rb))/float64(max(len(ra), len(rb)))
+	if sim < minTokenSimilarity {
+		return 0
+	}
+	return sim
+}
+
+// weighted share of the query tokens found in the title. title tokens are
+// also tried joined with their neighbour and neighbouring query tokens
+// joined, so "air pods" and "airpods" match both ways
+func tokenScore(query []string, title []string) float64 {
+	if len(query) == 0 {
+		return 1
+	}
+	candidates := slices.Clone(title)
+	for i := 0; i+1 < len(title); i++ {
+		candidates = append(candidates, title[i]+title[i+1])
+	}
+	scores := make([]float64, len(query))
+	for i, q := range query {
+		for _, c := range candidates {
+			scores[i] = max(scores[i], tokenSimilarity(q, c))
+		}
+	}
+	for i := 0; i+1 < len(query); i++ {
+		if slices.Contains(title, query[i]+query[i+1]) {
+			scores[i], scores[i+1] = 1, 1
+		}
+	}
+	var total, weights float64
+	for i, q := range query {
+		weight := 1.0
+		if isNumber(q) {
+			weight = numberTokenWeight
+		}
+		total += scores[i] * weight
+		weights += weight
+	}
+	return total / weights
+}
+
+// scores how well the title matches the include keywords and checks it
+// against the require any groups and exclusions. the score is returned
+// even when the title is rejected, rule is what rejected it
+func titleCorrectnessCheck(listingTitle string, rules *TitleRules) (float64, types.RejectReason, string) {
+	tokens := titleTokens(listingTitle)
+	score := tokenScore(rules.include, tokens)
+	if score < rules.minScore {
+		return score, types.RejectTitleMatch, fmt.Sprintf("%.0f%% needed", rules.minScore*100)
+	}
+	for i, group := range rules.requireAny {
+		found := false
+		for _, keyword := range group {
+			if tokenScore(keyword, tokens) >= rules.minScore {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return score, types.RejectRequireAny, rules.requireAnyRules[i]
+		}
+	}
+	normalized := normalizeTitle(listingTitle)
+	for _, exclude := range rules.exclude {
+		if match, err := exclude.re.MatchString(normalized); err == nil && match {
+			return score, types.RejectExcluded, exclude.rule
+		}
+	}
+	return score, "", ""
+}
+
+// whitelisted listings skip the title and distance checks
+func (r *TitleRules) allowed(uri string) bool {
+	return slices.Contains(r.allow, uri)
+}
+
+// scores the listing title into its Relevance and reports it when the
+// title is rejected
+func (r *TitleRules) keep(listing *types.EbayListing) bool {
+	score, reason, rule := titleCorrectnessCheck(listing.Title, r)
+	listing.Relevance = score
+	if reason == "" || r.allowed(listing.URL) {
+		return true
+	}
+	slog.Info("skipping title criteria not met", slog.String("Title", listing.Title),
+		slog.String("Reason", string(reason)), slog.String("Rule", rule), slog.Float64("Relevance", score))
+	r.reject(listing, reason, rule)
+	return false
+}
+
+// reports a listing that was dropped, safe to call on nil rules
+func (r *TitleRules) reject(listing *types.EbayListing, reason types.RejectReason, rule string) {
+	if r == nil || r.onReject == nil {
+		return
+	}
+	r.onReject(newRejection(r.source, listing, reason, rule))
+}
+
+func newRejection(source string, listing *types.EbayListing, reason types.RejectReason, rule string) types.RejectedListing {
+	// the same listing can be rejected for several items of a channel
+	sum := sha1.Sum([]byte(listing.ItemName + "|" + listing.URL))
+	return types.RejectedListing{
+		ID:        hex.EncodeToString(sum[:])[:12],
+		Source:    source,
+		Title:     listing.Title,
+		URL:       listing.URL,
+		Price:     listing.Price,
+		Reason:    reason,
+		Rule:      rule,
+		Relevance: listing.Relevance,
+		Date:      time.Now(),
+	}
+}
+
+// whether the relax button can loosen the rule behind a rejection, price
+// and distance limits come from the channel and the item price
+func CanRelax(rejection types.RejectedListing) bool {
+	switch rejection.Reason {
+	case types.RejectTitleMatch:
+		return rejection.Relevance > 0
+	case types.RejectRequireAny, types.RejectExcluded:
+		return true
+	}
+	return false
+}
+
+// loosens the filter just enough to let the rejected listing through
+func RelaxTitleFilter(filter types.TitleFilter, itemType string, rejection types.RejectedListing) (types.TitleFilter, error) {
+	switch rejection.Reason {
+	case types.RejectTitleMatch:
+		score := math.Floor(rejection.Relevance*100) / 100
+		if score <= 0 {
+			return filter, fmt.Errorf("no keyword of the item matched, whitelist the listing instead")
+		}
+		filter.MinScore = score
+	case types.RejectRequireAny:
+		filter.RequireAny = slices.DeleteFunc(slices.Clone(filter.RequireAny), func(group []string) bool {
+			return strings.Join(group, ", ") == rejection.Rule
+		})
+	case types.RejectExcluded:
+		switch {
+		case slices.Contains(filter.Exclude, rejection.Rule):
+			filter.Exclude = slices.DeleteFunc(slices.Clone(filter.Exclude), func(s string) bool { return s == rejection.Rule })
+		case slices.Contains(filter.ExcludeRegexes, rejection.Rule):
+			filter.ExcludeRegexes = slices.DeleteFunc(slices.Clone(filter.ExcludeRegexes), func(s string) bool { return s == rejection.Rule })
+		case !filter.IgnoreDefaults && slices.Contains(DefaultTitleExcludes(itemType), rejection.Rule):
+			// only the one default is dropped, the rest become item regexes
+			filter.IgnoreDefaults = true
+			for _, pattern := range DefaultTitleExcludes(itemType) {
+				if pattern != rejection.Rule && !slices.Contains(filter.ExcludeRegexes, pattern) {
+					filter.ExcludeRegexes = append(filter.ExcludeRegexes, pattern)
+				}
+			}
+		default:
+			return filter, fmt.Errorf("exclusion %s is not in the item filters anymore", rejection.Rule)
+		}
+	default:
+		return filter, fmt.Errorf("%s can't be relaxed, whitelist the listing instead", rejection.Reason)
+	}
+	return filter, nil
+}
@@ -0,0 +1,235 @@
+package crawler
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	types "priceTracker/Types"
+)
+
+func TestTitleTokens(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+	}{
+		{"RTX 3060Ti", []string{"rtx", "3060", "ti"}},
+		{"rtx 3060 ti", []string{"rtx", "3060", "ti"}},
+		{"Grey Colour PlayStation 5", []string{"gray", "color", "ps", "5"}},
+		{"AirPods Pro (2nd Gen.)", []string{"airpods", "pro", "2", "nd", "gen"}},
+		{"a1b", []string{"a", "1", "b"}},
+		{"  --  ", nil},
+	} {
+		if got := titleTokens(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("titleTokens(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTokenScore(t *testing.T) {
+	for _, tt := range []struct {
+		query, title string
+		want         float64
+	}{
+		{"3060ti", "EVGA RTX 3060 Ti XC", 1},
+		{"3060 ti", "EVGA RTX 3060Ti XC", 1},
+		{"air pods", "Apple AirPods Pro", 1},
+		{"airpods", "Apple Air Pods Pro", 1},
+		{"playstation 5", "PS5 Console Disc Edition", 1},
+		// model numbers count double and only match exactly
+		{"rtx 3060 ti", "RTX 3070 Ti", 0.5},
+		{"rtx 3060 ti", "RTX 3060", 0.75},
+		// short tokens only match exactly
+		{"rtx 3060 ti", "RTX 3060 To", 0.75},
+		{"logitech mouse", "Logitec mouse", 0.9375},
+		{"", "anything", 1},
+	} {
+		got := tokenScore(titleTokens(tt.query), titleTokens(tt.title))
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("tokenScore(%q, %q) = %v, want %v", tt.query, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestTitleCorrectnessCheck(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		itemName   string
+		itemType   string
+		filter     types.TitleFilter
+		title      string
+		wantScore  float64
+		wantReason types.RejectReason
+		wantRule   string
+	}{
+		{"match", "rtx 3060 ti", "Tech", types.TitleFilter{}, "EVGA RTX 3060Ti XC Gaming", 1, "", ""},
+		{"too different", "rtx 3060 ti", "Tech", types.TitleFilter{}, "RTX 3070 Ti", 0.5,
+			types.RejectTitleMatch, "80% needed"},
+		{"lower min score", "rtx 3060 ti", "Tech", types.TitleFilter{MinScore: 0.5}, "RTX 3070 Ti", 0.5, "", ""},
+		{"default exclusion", "rtx 3060 ti", "Tech", types.TitleFilter{}, "RTX 3060 Ti for parts", 1,
+			types.RejectExcluded, `\bfor parts`},
+		{"defaults ignored", "rtx 3060 ti", "Tech", types.TitleFilter{IgnoreDefaults: true},
+			"RTX 3060 Ti for parts", 1, "", ""},
+		{"item exclusion", "rtx 3060 ti", "Tech", types.TitleFilter{Exclude: []string{"Mining"}},
+			"RTX 3060 Ti ex mining card", 1, types.RejectExcluded, "Mining"},
+		{"item regex", "rtx 3060 ti", "Tech", types.TitleFilter{ExcludeRegexes: []string{`\blhr\b`}},
+			"RTX 3060 Ti LHR", 1, types.RejectExcluded, `\blhr\b`},
+		{"require any missing", "rtx 3060", "Tech", types.TitleFilter{RequireAny: [][]string{{"ti", "super"}}},
+			"RTX 3060 12GB", 1, types.RejectRequireAny, "ti, super"},
+		{"require any found", "rtx 3060", "Tech", types.TitleFilter{RequireAny: [][]string{{"ti", "super"}}},
+			"RTX 3060Ti 8GB", 1, "", ""},
+		{"include keywords", "rtx 3060 ti", "Tech", types.TitleFilter{Include: []string{"3060", "ti"}},
+			"Nvidia 3060 Ti", 1, "", ""},
+		{"tech only exclusion", "carhartt detroit jacket", "Tech", types.TitleFilter{},
+			"Carhartt Detroit Jacket stand collar", 1, types.RejectExcluded, `\bstand\b`},
+		{"clothes profile", "carhartt detroit jacket", "Clothes", types.TitleFilter{},
+			"Carhartt Detroit Jacket stand collar", 1, "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := NewTitleRules(tt.itemName, tt.itemType, tt.filter)
+			score, reason, rule := titleCorrectnessCheck(tt.title, rules)
+			if math.Abs(score-tt.wantScore) > 1e-9 || reason != tt.wantReason || rule != tt.wantRule {
+				t.Errorf("got %v %q %q, want %v %q %q", score, reason, rule, tt.wantScore, tt.wantReason, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestTitleRulesKeep(t *testing.T) {
+	rules, rejected := testRules("rtx 3060 ti", "Tech", types.TitleFilter{
+		AllowURLs: []string{"https://www.ebay.com/itm/1"},
+	})
+	allowed := &types.EbayListing{Title: "RTX 3060 Ti broken fan", URL: "https://www.ebay.com/itm/1"}
+	if !rules.keep(allowed) {
+		t.Error("whitelisted listing was dropped")
+	}
+	dropped := &types.EbayListing{Title: "RTX 3060 Ti broken fan", URL: "https://www.ebay.com/itm/2"}
+	if rules.keep(dropped) {
+		t.Error("excluded listing was kept")
+	}
+	if dropped.Relevance != 1 {
+		t.Errorf("got relevance %v, the score is kept on rejected listings", dropped.Relevance)
+	}
+	if len(*rejected) != 1 || (*rejected)[0].URL != dropped.URL || (*rejected)[0].Source != "test" {
+		t.Errorf("got rejections %+v", *rejected)
+	}
+}
+
+func TestNewRejectionID(t *testing.T) {
+	listing := &types.EbayListing{ItemName: "rtx 3060 ti", URL: "https://www.ebay.com/itm/1"}
+	first := newRejection("ebay", listing, types.RejectExcluded, "mining")
+	if again := newRejection("ebay", listing, types.RejectPrice, "$300.00"); again.ID != first.ID {
+		t.Errorf("got ID %s for the same listing, want %s", again.ID, first.ID)
+	}
+	other := *listing
+	other.ItemName = "rtx 3060"
+	if r := newRejection("ebay", &other, types.RejectExcluded, "mining"); r.ID == first.ID {
+		t.Errorf("listing rejected for two items got the same ID %s", r.ID)
+	}
+}
+
+func equalFilters(a, b types.TitleFilter) bool {
+	return slices.Equal(a.Include, b.Include) &&
+		slices.EqualFunc(a.RequireAny, b.RequireAny, slices.Equal) &&
+		slices.Equal(a.Exclude, b.Exclude) &&
+		slices.Equal(a.ExcludeRegexes, b.ExcludeRegexes) &&
+		a.IgnoreDefaults == b.IgnoreDefaults &&
+		a.MinScore == b.MinScore &&
+		slices.Equal(a.AllowURLs, b.AllowURLs)
+}
+
+func TestRelaxTitleFilter(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		itemType  string
+		filter    types.TitleFilter
+		rejection types.RejectedListing
+		want      types.TitleFilter
+		wantErr   bool
+	}{
+		{
+			name:      "min score drops to the listing",
+			filter:    types.TitleFilter{AllowURLs: []string{"https://www.ebay.com/itm/1"}},
+			rejection: types.RejectedListing{Reason: types.RejectTitleMatch, Relevance: 0.6667},
+			want:      types.TitleFilter{MinScore: 0.66, AllowURLs: []string{"https://www.ebay.com/itm/1"}},
+		},
+		{
+			name:      "nothing matched",
+			rejection: types.RejectedListing{Reason: types.RejectTitleMatch, Relevance: 0},
+			wantErr:   true,
+		},
+		{
+			name:      "require any group",
+			filter:    types.TitleFilter{RequireAny: [][]string{{"ti", "super"}, {"8gb"}}},
+			rejection: types.RejectedListing{Reason: types.RejectRequireAny, Rule: "ti, super"},
+			want:      types.TitleFilter{RequireAny: [][]string{{"8gb"}}},
+		},
+		{
+			name:      "item exclusion",
+			filter:    types.TitleFilter{Exclude: []string{"mining", "lhr"}},
+			rejection: types.RejectedListing{Reason: types.RejectExcluded, Rule: "mining"},
+			want:      types.TitleFilter{Exclude: []string{"lhr"}},
+		},
+		{
+			name:      "item regex",
+			filter:    types.TitleFilter{ExcludeRegexes: []string{`\bbox\b`}},
+			rejection: types.RejectedListing{Reason: types.RejectExcluded, Rule: `\bbox\b`},
+			want:      types.TitleFilter{},
+		},
+		{
+			name:      "default exclusion",
+			itemType:  "Clothes",
+			filter:    types.TitleFilter{ExcludeRegexes: []string{`\bkids\b`}},
+			rejection: types.RejectedListing{Reason: types.RejectExcluded, Rule: "damage"},
+			want: types.TitleFilter{
+				IgnoreDefaults: true,
+				ExcludeRegexes: []string{`\bkids\b`, `\bjunior\b`, `\bdefective`},
+			},
+		},
+		{
+			name:      "default already dropped",
+			itemType:  "Clothes",
+			filter:    types.TitleFilter{IgnoreDefaults: true},
+			rejection: types.RejectedListing{Reason: types.RejectExcluded, Rule: "damage"},
+			wantErr:   true,
+		},
+		{
+			name:      "price",
+			rejection: types.RejectedListing{Reason: types.RejectPrice, Rule: "$300.00"},
+			wantErr:   true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RelaxTitleFilter(tt.filter, tt.itemType, tt.rejection)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !equalFilters(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			// the relaxed filter is saved as is, so it has to stay valid
+			if err == nil {
+				if err := ValidateTitleFilter(got); err != nil {
+					t.Errorf("relaxed filter is invalid: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTitleFilter(t *testing.T) {
+	for _, tt := range []struct {
+		filter  types.TitleFilter
+		wantErr bool
+	}{
+		{types.TitleFilter{}, false},
+		{types.TitleFilter{ExcludeRegexes: []string{`(?=.*\bonly\b)(?=.*\bbox\b)`}, MinScore: 1}, false},
+		{types.TitleFilter{ExcludeRegexes: []string{`(unclosed`}}, true},
+		{types.TitleFilter{MinScore: 1.5}, true},
+		{types.TitleFilter{MinScore: -0.1}, true},
+	} {
+		if err := ValidateTitleFilter(tt.filter); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateTitleFilter(%+v) = %v, want error %v", tt.filter, err, tt.wantErr)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"log/slog"
 	"time"
 
+	types "priceTracker/Types"
+
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 )
@@ -32,6 +34,7 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 					{Key: "Date", Value: "$PriceHistory.Date"},
 					{Key: "Price", Value: "$PriceHistory.Price"},
 					{Key: "Url", Value: "$PriceHistory.Url"},
+					{Key: "Availability", Value: "$PriceHistory.Availability"},
 				},
 			},
 		},
@@ -56,6 +59,7 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 			}},
 		}}},
 		bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$ListingsHistory"}}}},
+		usedTierMatch(),
 		bson.D{
 			{Key: "$group", Value: bson.D{
 				{Key: "_id", Value: bson.D{
@@ -64,8 +68,8 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 						{Key: "unit", Value: "day"},
 					}},
 				}},
-				{Key: "AVGPrice", Value: bson.D{{Key: "$avg", Value: "$ListingsHistory.Price"}}},
-				{Key: "STDEV", Value: bson.D{{Key: "$stdDevPop", Value: "$ListingsHistory.Price"}}},
+				{Key: "AVGPrice", Value: bson.D{{Key: "$avg", Value: "$ListingsHistory.Price.Amount"}}},
+				{Key: "STDEV", Value: bson.D{{Key: "$stdDevPop", Value: "$ListingsHistory.Price.Amount"}}},
 				{Key: "ListingsHistory", Value: bson.D{{Key: "$push", Value: "$ListingsHistory"}}},
 			}},
 		},
@@ -74,7 +78,7 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 			{Key: "$match", Value: bson.D{
 				{Key: "$expr", Value: bson.D{
 					{Key: "$gte", Value: bson.A{
-						"$ListingsHistory.Price",
+						"$ListingsHistory.Price.Amount",
 						bson.D{
 							{Key: "$subtract", Value: bson.A{
 								"$AVGPrice",
@@ -98,12 +102,13 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 						{Key: "unit", Value: "day"},
 					}},
 				}},
-				{Key: "Price", Value: bson.D{{Key: "$avg", Value: "$ListingsHistory.Price"}}},
+				{Key: "Price", Value: bson.D{{Key: "$avg", Value: "$ListingsHistory.Price.Amount"}}},
+				{Key: "Currency", Value: bson.D{{Key: "$first", Value: "$ListingsHistory.Price.Currency"}}},
 			}},
 		},
 		bson.D{
 			{Key: "$project", Value: bson.D{
-				{Key: "Price", Value: bson.D{{Key: "$toInt", Value: "$Price"}}},
+				{Key: "Price", Value: moneyExpr("$Price")},
 				{Key: "Date", Value: "$_id"},
 				{Key: "Url", Value: "USED"},
 			}},
@@ -128,6 +133,7 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 			}},
 		}}},
 		bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$ListingsHistory"}}}},
+		usedTierMatch(),
 		bson.D{
 			{Key: "$group", Value: bson.D{
 				{Key: "_id", Value: bson.D{
@@ -136,8 +142,8 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 						{Key: "unit", Value: "day"},
 					}},
 				}},
-				{Key: "AVGPrice", Value: bson.D{{Key: "$avg", Value: "$ListingsHistory.Price"}}},
-				{Key: "STDEV", Value: bson.D{{Key: "$stdDevPop", Value: "$ListingsHistory.Price"}}},
+				{Key: "AVGPrice", Value: bson.D{{Key: "$avg", Value: "$ListingsHistory.Price.Amount"}}},
+				{Key: "STDEV", Value: bson.D{{Key: "$stdDevPop", Value: "$ListingsHistory.Price.Amount"}}},
 				{Key: "ListingsHistory", Value: bson.D{{Key: "$push", Value: "$ListingsHistory"}}},
 			}},
 		},
@@ -146,7 +152,7 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 			{Key: "$match", Value: bson.D{
 				{Key: "$expr", Value: bson.D{
 					{Key: "$gte", Value: bson.A{
-						"$ListingsHistory.Price",
+						"$ListingsHistory.Price.Amount",
 						bson.D{
 							{Key: "$subtract", Value: bson.A{
 								"$AVGPrice",
@@ -170,12 +176,13 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 						{Key: "unit", Value: "day"},
 					}},
 				}},
-				{Key: "Price", Value: bson.D{{Key: "$min", Value: "$ListingsHistory.Price"}}},
+				{Key: "Price", Value: bson.D{{Key: "$min", Value: "$ListingsHistory.Price.Amount"}}},
+				{Key: "Currency", Value: bson.D{{Key: "$first", Value: "$ListingsHistory.Price.Currency"}}},
 			}},
 		},
 		bson.D{
 			{Key: "$project", Value: bson.D{
-				{Key: "Price", Value: bson.D{{Key: "$toInt", Value: "$Price"}}},
+				{Key: "Price", Value: moneyExpr("$Price")},
 				{Key: "Date", Value: "$_id"},
 				{Key: "Url", Value: "USED-LOWEST"},
 			}},
@@ -190,9 +197,52 @@ func GetPriceHistory(Name string, date time.Time, ChannelID string) ([]*Price, e
 		slog.Error("couldnt aggregate", slog.Any("Error", err))
 		return newRes, err
 	}
+	newRes = append(newRes, usedLowestRes...)
+
+	// ------------ pipeline for getting refurbished Price -------------
+	// certified listings barely vary so the lowest of the day is enough
+	var refurbishedRes []*Price
+	refurbishedPipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "Name", Value: bson.D{
+				{Key: "$regex", Value: "^" + Name + "$"},
+				{Key: "$options", Value: "i"},
+			}},
+		}}},
+		bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$ListingsHistory"}}}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "ListingsHistory.Tier", Value: types.TierRefurbished}}}},
+		bson.D{
+			{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{
+					{Key: "$dateTrunc", Value: bson.D{
+						{Key: "date", Value: "$ListingsHistory.Date"},
+						{Key: "unit", Value: "day"},
+					}},
+				}},
+				{Key: "Price", Value: bson.D{{Key: "$min", Value: "$ListingsHistory.Price.Amount"}}},
+				{Key: "Currency", Value: bson.D{{Key: "$first", Value: "$ListingsHistory.Price.Currency"}}},
+			}},
+		},
+		bson.D{
+			{Key: "$project", Value: bson.D{
+				{Key: "Price", Value: moneyExpr("$Price")},
+				{Key: "Date", Value: "$_id"},
+				{Key: "Url", Value: "REFURBISHED"},
+			}},
+		},
+	}
+	cursor, err = Table.Aggregate(ctx, refurbishedPipeline)
+	if err != nil {
+		slog.Error("couldnt aggregate", slog.Any("Error", err))
+		return newRes, err
+	}
+	if err = cursor.All(ctx, &refurbishedRes); err != nil {
+		slog.Error("couldnt aggregate", slog.Any("Error", err))
+		return newRes, err
+	}
 	defer cursor.Close(ctx)
 
-	return append(newRes, usedLowestRes...), err
+	return append(newRes, refurbishedRes...), err
 }
 
 func GenerateSecondHandPriceReport(Name string, endDate time.Time, Days int, ChannelID string) (AggregateReport, error) {
@@ -204,11 +254,13 @@ func GenerateSecondHandPriceReport(Name string, endDate time.Time, Days int, Cha
 	pipeline := mongo.Pipeline{
 		bson.D{{Key: "$match", Value: bson.D{{Key: "Name", Value: Name}}}},
 		bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$ListingsHistory"}}}},
+		usedTierMatch(),
 		bson.D{
 			{Key: "$project", Value: bson.D{
 				{Key: "URL", Value: "$ListingsHistory.URL"},
 				{Key: "Date", Value: "$ListingsHistory.Date"},
-				{Key: "Price", Value: "$ListingsHistory.Price"},
+				{Key: "Price", Value: "$ListingsHistory.Price.Amount"},
+				{Key: "Currency", Value: "$ListingsHistory.Price.Currency"},
 			}},
 		},
 		bson.D{
@@ -261,6 +313,7 @@ func GenerateSecondHandPriceReport(Name string, endDate time.Time, Days int, Cha
 				{Key: "priceWhenSold", Value: bson.D{{Key: "$last", Value: "$ListingsHistory.Price"}}},
 				{Key: "averagePrice", Value: bson.D{{Key: "$avg", Value: "$ListingsHistory.Price"}}},
 				{Key: "LowestPriceDuringTimePeriod", Value: bson.D{{Key: "$min", Value: "$ListingsHistory.Price"}}},
+				{Key: "Currency", Value: bson.D{{Key: "$last", Value: "$ListingsHistory.Currency"}}},
 			}},
 		},
 		bson.D{
@@ -277,6 +330,7 @@ func GenerateSecondHandPriceReport(Name string, endDate time.Time, Days int, Cha
 				{Key: "priceWhenSold", Value: "$priceWhenSold"},
 				{Key: "averagePrice", Value: "$averagePrice"},
 				{Key: "LowestPriceDuringTimePeriod", Value: "$LowestPriceDuringTimePeriod"},
+				{Key: "Currency", Value: "$Currency"},
 			}},
 		},
 		bson.D{
@@ -296,16 +350,17 @@ func GenerateSecondHandPriceReport(Name string, endDate time.Time, Days int, Cha
 				{Key: "PriceSTDEV", Value: bson.D{{Key: "$stdDevSamp", Value: "$LowestPriceDuringTimePeriod"}}},
 				{Key: "UniqueListings", Value: bson.D{{Key: "$sum", Value: 1}}},
 				{Key: "LowestPriceDuringTimePeriod", Value: bson.D{{Key: "$min", Value: "$LowestPriceDuringTimePeriod"}}},
+				{Key: "Currency", Value: bson.D{{Key: "$first", Value: "$Currency"}}},
 			}},
 		},
 		bson.D{
 			{Key: "$project", Value: bson.D{
 				{Key: "AverageDaysUP", Value: bson.D{{Key: "$toInt", Value: "$AverageDaysUP"}}},
-				{Key: "AveragePriceWhenSold", Value: bson.D{{Key: "$toInt", Value: "$AveragePriceWhenSold"}}},
-				{Key: "AveragePrice", Value: bson.D{{Key: "$toInt", Value: "$AveragePrice"}}},
-				{Key: "PriceSTDEV", Value: bson.D{{Key: "$toInt", Value: "$PriceSTDEV"}}},
+				{Key: "AveragePriceWhenSold", Value: moneyExpr("$AveragePriceWhenSold")},
+				{Key: "AveragePrice", Value: moneyExpr("$AveragePrice")},
+				{Key: "PriceSTDEV", Value: moneyExpr("$PriceSTDEV")},
 				{Key: "UniqueListings", Value: "$UniqueListings"},
-				{Key: "LowestPriceDuringTimePeriod", Value: bson.D{{Key: "$toInt", Value: "$LowestPriceDuringTimePeriod"}}},
+				{Key: "LowestPriceDuringTimePeriod", Value: moneyExpr("$LowestPriceDuringTimePeriod")},
 			}},
 		},
 	}
@@ -319,10 +374,13 @@ func GenerateSecondHandPriceReport(Name string, endDate time.Time, Days int, Cha
 		slog.Error("couldnt aggregate", slog.Any("Error", err))
 		return AggregateReport{}, err
 	}
-	if len(res) == 0 {
-		return AggregateReport{}, err
+	// items can have sales without any listings of ours in the time period
+	report := AggregateReport{}
+	if len(res) != 0 {
+		report = *res[0]
 	}
-	return *res[0], err
+	err = addSoldStats(&report, Name, endDate, Days, ChannelID)
+	return report, err
 }
 
 func UpdateAggregateReport(Name, ChannelID string) error {
@@ -353,3 +411,20 @@ func UpdateAggregateReport(Name, ChannelID string) error {
 	}
 	return nil
 }
+
+// refurbished listings are in ListingsHistory too, the used stats and
+// lines skip them
+func usedTierMatch() bson.D {
+	return bson.D{{Key: "$match", Value: bson.D{
+		{Key: "ListingsHistory.Tier", Value: bson.D{{Key: "$ne", Value: types.TierRefurbished}}},
+	}}}
+}
+
+// builds a Money document from a minor unit amount computed in a pipeline,
+// the stage has to carry the Currency field along
+func moneyExpr(amount string) bson.D {
+	return bson.D{
+		{Key: "Amount", Value: bson.D{{Key: "$toLong", Value: amount}}},
+		{Key: "Currency", Value: "$Currency"},
+	}
+}
@@ -1,8 +1,11 @@
 package database
 
 import (
+	"fmt"
 	"log/slog"
 
+	crawler "priceTracker/Crawler"
+
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 )
@@ -80,14 +83,21 @@ func AutoCompleteURL(Name string, ChannelID string) []string {
 	return res
 }
 
+// selectors offered for the html tag option, one per site profile
+// selector so older selectors stay available as fallbacks
 func AutoCompleteQuery() map[string]string {
 	ret := map[string]string{
-		"Amazon":       "form#addToCart span.a-price-whole",
-		"NewEgg":       "div.price-current>strong",
-		"MicroCenter":  "#options-pricing2022",
-		"BHPhotoVideo": "span[class^='price_']",
-		// "BestBuy":      "div[data-component-name='LargePrice'] div[data-testid='price-block-customer-price']",
-		"BestBuy": "div[data-testid='price-block-customer-price']",
+		// reads json-ld, microdata or og:price tags instead of a selector
+		"Auto (Structured Data)": crawler.AutoSelector,
+	}
+	for _, profile := range crawler.SiteProfiles() {
+		for index, selector := range profile.PriceSelectors {
+			name := profile.Name
+			if index != 0 {
+				name = fmt.Sprintf("%s (fallback %d)", profile.Name, index)
+			}
+			ret[name] = selector
+		}
 	}
 	return ret
 }
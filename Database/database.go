@@ -8,6 +8,8 @@ import (
 	"net/url"
 	"os"
 	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	crawler "priceTracker/Crawler"
@@ -23,19 +25,40 @@ import (
 type TrackingInfo struct {
 	URI       string `bson:"URI"`
 	HtmlQuery string `bson:"HtmlQuery"`
+	// how the price was found when the tracker was added, css or one of
+	// the structured data strategies when HtmlQuery is auto
+	Strategy string `bson:"Strategy"`
+	// stock status from the last crawl, used for back in stock alerts
+	Availability crawler.Availability `bson:"Availability"`
+	// flat shipping added to every price from this tracker, opt in
+	Shipping types.Money `bson:"Shipping"`
+	// currency the page prices in, shipping is in this currency too
+	Currency string `bson:"Currency"`
 }
 type Price struct {
-	Date  time.Time `bson:"Date"`
-	Price int       `bson:"Price"`
-	Url   string    `bson:"Url"`
+	Date time.Time `bson:"Date"`
+	// landed price with the channel tax and tracker shipping converted to
+	// the channel currency, this is what gets compared against second hand
+	// listings
+	Price types.Money `bson:"Price"`
+	// price as shown on the page, in the page currency
+	RawPrice     types.Money          `bson:"RawPrice"`
+	Url          string               `bson:"Url"`
+	Availability crawler.Availability `bson:"Availability"`
 }
 type AggregateReport struct {
-	UniqueListings              int `bson:"UniqueListings"`
-	AverageDaysUP               int `bson:"AverageDaysUP"`
-	AveragePrice                int `bson:"AveragePrice"`
-	PriceSTDEV                  int `bson:"PriceSTDEV"`
-	AveragePriceWhenSold        int `bson:"AveragePriceWhenSold"`
-	LowestPriceDuringTimePeriod int `bson:"LowestPriceDuringTimePeriod"`
+	UniqueListings              int         `bson:"UniqueListings"`
+	AverageDaysUP               int         `bson:"AverageDaysUP"`
+	AveragePrice                types.Money `bson:"AveragePrice"`
+	PriceSTDEV                  types.Money `bson:"PriceSTDEV"`
+	AveragePriceWhenSold        types.Money `bson:"AveragePriceWhenSold"`
+	LowestPriceDuringTimePeriod types.Money `bson:"LowestPriceDuringTimePeriod"`
+	// from the ebay sold history, zero when nothing sold in the time period
+	SoldListings     int         `bson:"SoldListings"`
+	AverageSoldPrice types.Money `bson:"AverageSoldPrice"`
+	LowestSoldPrice  types.Money `bson:"LowestSoldPrice"`
+	HighestSoldPrice types.Money `bson:"HighestSoldPrice"`
+	LastSoldDate     time.Time   `bson:"LastSoldDate"`
 }
 type Item struct {
 	Name                  string               `bson:"Name"`
@@ -50,6 +73,33 @@ type Item struct {
 	ListingsHistory       []*types.EbayListing `bson:"ListingsHistory"`
 	SevenDayAggregate     AggregateReport      `bson:"SevenDayAggregate"`
 	SuppressNotifications bool                 `bson:"SuppressNotifications"`
+	// overrides the channel second hand sources when not empty, AllSources
+	// crawls every source even when the channel narrows them
+	Sources []string `bson:"Sources"`
+	// which second hand listing titles count as the item
+	Filter types.TitleFilter `bson:"Filter"`
+	// latest second hand listings that were dropped, newest first
+	Rejected []types.RejectedListing `bson:"Rejected"`
+	// when the ebay sold listings were last crawled, they are only
+	// crawled once a day
+	LastSoldCrawl time.Time `bson:"LastSoldCrawl"`
+	// also tracks ebay auctions under the price
+	AuctionMode bool `bson:"AuctionMode"`
+	// how long before an auction ends the alert is sent, 0 uses
+	// DefaultAuctionAlertMinutes
+	AuctionAlertMinutes int `bson:"AuctionAlertMinutes"`
+	// urls of the auctions the ending soon alert went out for, kept apart
+	// from EbayListings so a crawl replacing the listings can't undo one
+	AlertedAuctions []string `bson:"AlertedAuctions"`
+}
+
+const DefaultAuctionAlertMinutes = 30
+
+func (i *Item) AuctionAlertBefore() time.Duration {
+	if i.AuctionAlertMinutes <= 0 {
+		return DefaultAuctionAlertMinutes * time.Minute
+	}
+	return time.Duration(i.AuctionAlertMinutes) * time.Minute
 }
 
 var (
@@ -71,17 +121,26 @@ func AddItem(itemName string, uri string, query string, Type string, Timer int,
 	if Timer <= 0 {
 		return Item{}, errors.New("Invalid Timer value")
 	}
-	p, t, err := validateURI(uri, query)
+	p, t, err := validateURI(uri, query, Channel.ChannelID)
 	if err != nil {
 		slog.Error("invalid url for add", slog.Any("Error", err))
 		return Item{}, err
 	}
-	imgURL := crawler.GetOpenGraphPic(uri)
-	ebayListings, _ := crawler.GetSecondHandListings(itemName, p.Price,
-		Channel.Lat, Channel.Long, Channel.Distance, Type, Channel.LocationCode)
-	slices.SortFunc(ebayListings, func(a, b *types.EbayListing) int {
-		return b.Price - a.Price
-	})
+	imgURL := crawler.GetOpenGraphPic(ctx, uri)
+	var rejected RejectionBuffer
+	ebayListings, _ := crawler.GetSecondHandListings(ctx, crawler.SecondHandQuery{
+		Name:         itemName,
+		Price:        p.Price,
+		ItemType:     Type,
+		Lat:          Channel.Lat,
+		Long:         Channel.Long,
+		Distance:     Channel.Distance,
+		MaxDriveTime: Channel.MaxDriveTime,
+		LocationCode: Channel.LocationCode,
+		TaxRate:      Channel.TaxRate,
+		OnReject:     rejected.Add,
+	}, Channel.Sources)
+	sortListingsByPrice(ebayListings)
 	arr := []*TrackingInfo{t}
 	PriceArr := []*Price{p}
 	i := Item{
@@ -102,12 +161,15 @@ func AddItem(itemName string, uri string, query string, Type string, Timer int,
 		slog.Error("Error", slog.Any("Error", err))
 	}
 	UpdateAggregateReport(itemName, Channel.ChannelID)
+	if err := AddRejections(itemName, rejected.Rejected, Channel.ChannelID); err != nil {
+		slog.Error("could not save rejected listings", slog.Any("Error", err))
+	}
 	i, err = GetItem(itemName, Channel.ChannelID)
 	return i, err
 }
 
 // removes all trackers and manually sets the price to filter second hand listingsArr
-func SetDesiredPrice(Name, ChannelID string, price int) error {
+func SetDesiredPrice(Name, ChannelID string, price types.Money) error {
 	item, err := GetItem(Name, ChannelID)
 	if err != nil {
 		slog.Error("Error getting Item in setdesiredprice",
@@ -126,9 +188,10 @@ func SetDesiredPrice(Name, ChannelID string, price int) error {
 	}
 
 	DesiredPrice := &Price{
-		Price: price,
-		Date:  time.Now(),
-		Url:   "Don't Worry About It",
+		Price:    price,
+		RawPrice: price,
+		Date:     time.Now(),
+		Url:      "Don't Worry About It",
 	}
 	_, err = UpdateLowestPrice(Name, DesiredPrice, ChannelID)
 	if err != nil {
@@ -172,6 +235,196 @@ func EditSuppress(Name string, Suppress bool, ChannelID string) error {
 	return res.Err()
 }
 
+// nil sources makes the item fall back to the channel sources
+func EditSources(Name string, sources []string, ChannelID string) error {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("Could not load channel from db", slog.Any("Error", err))
+		return err
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"Sources": sources,
+		},
+	}
+	res := Table.FindOneAndUpdate(ctx, bson.M{"Name": bson.M{"$regex": "^" + Name + "$", "$options": "i"}}, update)
+	return res.Err()
+}
+
+// a zero filter puts the item back on the defaults of its type
+func EditTitleFilter(Name string, filter types.TitleFilter, ChannelID string) error {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("Could not load channel from db", slog.Any("Error", err))
+		return err
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"Filter": filter,
+		},
+	}
+	res := Table.FindOneAndUpdate(ctx, bson.M{"Name": bson.M{"$regex": "^" + Name + "$", "$options": "i"}}, update)
+	return res.Err()
+}
+
+// rejections kept per item, older ones are dropped
+const maxRejections = 25
+
+// collects the rejections of one crawl, Add is passed as the query OnReject
+type RejectionBuffer struct {
+	mu       sync.Mutex
+	Rejected []types.RejectedListing
+}
+
+func (b *RejectionBuffer) Add(r types.RejectedListing) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Rejected = append(b.Rejected, r)
+}
+
+// minutes of 0 keeps the current alert time
+func EditAuctionMode(Name string, enabled bool, minutes int, ChannelID string) error {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("Could not load channel from db", slog.Any("Error", err))
+		return err
+	}
+	if minutes < 0 {
+		return errors.New("Invalid alert time")
+	}
+	set := bson.M{"AuctionMode": enabled}
+	if minutes > 0 {
+		set["AuctionAlertMinutes"] = minutes
+	}
+	res := Table.FindOneAndUpdate(ctx, bson.M{"Name": bson.M{"$regex": "^" + Name + "$", "$options": "i"}},
+		bson.M{"$set": set})
+	return res.Err()
+}
+
+// stops the ending soon alert of the auction from going out again
+func MarkAuctionAlerted(Name string, URL string, ChannelID string) error {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("Could not load channel from db", slog.Any("Error", err))
+		return err
+	}
+	res := Table.FindOneAndUpdate(ctx, bson.M{"Name": Name},
+		bson.M{"$addToSet": bson.M{"AlertedAuctions": URL}})
+	return res.Err()
+}
+
+// drops auctions that are no longer listed from the alerted ones
+func ForgetAlertedAuctions(Name string, URLs []string, ChannelID string) error {
+	if len(URLs) == 0 {
+		return nil
+	}
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("Could not load channel from db", slog.Any("Error", err))
+		return err
+	}
+	res := Table.FindOneAndUpdate(ctx, bson.M{"Name": Name},
+		bson.M{"$pull": bson.M{"AlertedAuctions": bson.M{"$in": URLs}}})
+	return res.Err()
+}
+
+// merges the rejections of a crawl into the item, a listing rejected
+// again replaces its old entry
+func AddRejections(Name string, rejected []types.RejectedListing, ChannelID string) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+	item, err := GetItem(Name, ChannelID)
+	if err != nil {
+		return err
+	}
+	merged := slices.Clone(rejected)
+	slices.SortFunc(merged, func(a, b types.RejectedListing) int {
+		return b.Date.Compare(a.Date)
+	})
+	merged = append(merged, item.Rejected...)
+	seen := map[string]bool{}
+	merged = slices.DeleteFunc(merged, func(r types.RejectedListing) bool {
+		if seen[r.ID] {
+			return true
+		}
+		seen[r.ID] = true
+		return false
+	})
+	if len(merged) > maxRejections {
+		merged = merged[:maxRejections]
+	}
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("Could not load channel from db", slog.Any("Error", err))
+		return err
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"Rejected": merged,
+		},
+	}
+	res := Table.FindOneAndUpdate(ctx, bson.M{"Name": item.Name}, update)
+	return res.Err()
+}
+
+// finds the rejection and the item it belongs to, Name can be empty for
+// buttons that were sent without the item name
+func GetRejection(Name string, ID string, ChannelID string) (Item, types.RejectedListing, error) {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("couldnt load channel", slog.Any("Error", err))
+		return Item{}, types.RejectedListing{}, err
+	}
+	var item Item
+	opts := options.FindOne().SetProjection(bson.D{{Key: "PriceHistory", Value: 0}})
+	filter := bson.M{"Rejected.ID": ID}
+	if Name != "" {
+		filter["Name"] = Name
+	}
+	err = Table.FindOne(ctx, filter, opts).Decode(&item)
+	if err != nil {
+		return item, types.RejectedListing{}, err
+	}
+	for _, r := range item.Rejected {
+		if r.ID == ID {
+			return item, r, nil
+		}
+	}
+	return item, types.RejectedListing{}, mongo.ErrNoDocuments
+}
+
+func RemoveRejection(Name string, ID string, ChannelID string) error {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("Could not load channel from db", slog.Any("Error", err))
+		return err
+	}
+	update := bson.M{
+		"$pull": bson.M{
+			"Rejected": bson.M{"ID": ID},
+		},
+	}
+	res := Table.FindOneAndUpdate(ctx, bson.M{"Name": Name}, update)
+	return res.Err()
+}
+
+// stored as the only item source to opt the item back into every source,
+// an empty item list follows the channel instead
+const AllSources = "all"
+
+// returns the second hand sources that should be crawled for the item,
+// an empty list means every source is enabled
+func EnabledSources(item *Item, Channel *Channel) []string {
+	if len(item.Sources) == 1 && item.Sources[0] == AllSources {
+		return nil
+	}
+	if len(item.Sources) != 0 {
+		return item.Sources
+	}
+	return Channel.Sources
+}
+
 func EditName(oldName string, newName string, ChannelID string) (Item, error) {
 	Table, err := loadChannelTable(ChannelID)
 	if err != nil {
@@ -193,20 +446,32 @@ func EditName(oldName string, newName string, ChannelID string) (Item, error) {
 		)
 		return Item{}, err
 	}
+	if err := renameSoldListings(oldName, newName, ChannelID); err != nil {
+		slog.Error("failed to rename sold listings", slog.String("Name", oldName), slog.Any("Error", err))
+	}
 	return res, err
 }
 
-// method itself checks if the price is a duplicate and if so does not add it
-func AddNewPrice(Name string, uri string, newPrice int, date time.Time, ChannelID string) (Price, error) {
+// method itself checks if the price is a duplicate and if so does not add it,
+// rawPrice is the crawled price, the returned price has tax and shipping added
+func AddNewPrice(Name string, Tracker *TrackingInfo, rawPrice types.Money, availability crawler.Availability, date time.Time, ChannelID string) (Price, error) {
 	Table, err := loadChannelTable(ChannelID)
 	if err != nil {
 		slog.Error("couldnt load channel", slog.Any("Error", err))
 		return Price{}, err
 	}
+	uri := Tracker.URI
+	newPrice, err := landedPrice(rawPrice, Tracker.Shipping, ChannelID)
+	if err != nil {
+		slog.Error("couldnt convert new price", slog.String("URI", uri), slog.Any("Error", err))
+		return Price{}, err
+	}
 	price := Price{
-		Price: newPrice,
-		Url:   uri,
-		Date:  date,
+		Price:        newPrice,
+		RawPrice:     rawPrice,
+		Url:          uri,
+		Date:         date,
+		Availability: availability,
 	}
 
 	startOfDay := date.Truncate(24 * time.Hour)
@@ -248,7 +513,7 @@ func AddNewPrice(Name string, uri string, newPrice int, date time.Time, ChannelI
 	// Check if price unchanged today
 	if len(results) > 0 && len(results[0].PriceHistory) > 0 {
 		for _, p := range results[0].PriceHistory {
-			if p.Price == newPrice {
+			if p.Price == newPrice && p.Availability == availability {
 				slog.Info("Price Same, Skipping todays update")
 				return price, nil
 			}
@@ -259,7 +524,14 @@ func AddNewPrice(Name string, uri string, newPrice int, date time.Time, ChannelI
 	if err != nil {
 		panic(err)
 	}
-	if newPrice < historicalLow.Price {
+	// the low might be from before the channel currency was changed,
+	// without a rate the two can't be compared so the low is kept
+	low, err := crawler.ConvertMoney(historicalLow.Price, newPrice.Currency)
+	if err != nil {
+		slog.Error("could not convert the historical low", slog.String("Name", Name), slog.Any("Error", err))
+	}
+	// unavailable listings might show a stale price that can't be bought
+	if err == nil && newPrice.Cmp(low) < 0 && !newPrice.IsZero() && availability.Purchasable() {
 		UpdateLowestHistoricalPrice(Name, price, ChannelID)
 	}
 
@@ -278,6 +550,60 @@ func AddNewPrice(Name string, uri string, newPrice int, date time.Time, ChannelI
 	return price, nil
 }
 
+// stores the stock status of the tracker so the next crawl can tell
+// when an item comes back in stock
+func UpdateTrackerAvailability(Name string, uri string, availability crawler.Availability, ChannelID string) error {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("couldnt load channel", slog.Any("Error", err))
+		return err
+	}
+	filter := bson.M{
+		"Name":             Name,
+		"TrackingList.URI": uri,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"TrackingList.$.Availability": availability,
+		},
+	}
+	_, err = Table.UpdateOne(ctx, filter, update)
+	if err != nil {
+		slog.Error("could not update tracker availability", slog.Any("Error", err))
+	}
+	return err
+}
+
+// sets the flat shipping cost of the tracker at index, used for sites
+// that do not include shipping in the listed price
+func EditTrackerShipping(Name string, index int, shipping types.Money, ChannelID string) (Item, error) {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("couldnt load channel", slog.Any("Error", err))
+		return Item{}, err
+	}
+	item, err := GetItem(Name, ChannelID)
+	if err != nil {
+		return item, err
+	}
+	if index < 0 || index >= len(item.TrackingList) {
+		return item, errors.New("tracker not found for item")
+	}
+	filter := bson.M{"Name": item.Name}
+	update := bson.M{
+		"$set": bson.M{
+			fmt.Sprintf("TrackingList.%d.Shipping", index): shipping,
+		},
+	}
+	var res Item
+	opts := options.FindOneAndUpdate().SetProjection(bson.D{{Key: "PriceHistory", Value: 0}}).SetReturnDocument(options.After)
+	err = Table.FindOneAndUpdate(ctx, filter, update, opts).Decode(&res)
+	if err != nil {
+		slog.Error("could not update tracker shipping", slog.Any("Error", err))
+	}
+	return res, err
+}
+
 func GetLowestHistoricalPrice(Name string, ChannelID string) (Price, error) {
 	Table, err := loadChannelTable(ChannelID)
 	if err != nil {
@@ -391,6 +717,19 @@ func GetEbayListings(itemName string, ChannelID string) ([]*types.EbayListing, e
 	return res.EbayListings, err
 }
 
+// most expensive first. listings kept from a source that failed can still
+// be in an older channel currency, those are converted to compare and the
+// ones without a rate are grouped by currency
+func sortListingsByPrice(listings []*types.EbayListing) {
+	slices.SortFunc(listings, func(a, b *types.EbayListing) int {
+		c, err := crawler.CompareMoney(b.Price, a.Price)
+		if err != nil {
+			return strings.Compare(a.Price.Currency, b.Price.Currency)
+		}
+		return c
+	})
+}
+
 func UpdateEbayListings(itemName string, listingsArr []*types.EbayListing, ChannelID string) error {
 	Table, err := loadChannelTable(ChannelID)
 	if err != nil {
@@ -398,9 +737,7 @@ func UpdateEbayListings(itemName string, listingsArr []*types.EbayListing, Chann
 		return err
 	}
 	filter := bson.M{"Name": itemName}
-	slices.SortFunc(listingsArr, func(a, b *types.EbayListing) int {
-		return b.Price - a.Price
-	})
+	sortListingsByPrice(listingsArr)
 	startOfDay := time.Now().Truncate(24 * time.Hour)
 	var filteredListigArr []*types.EbayListing // filtered array
 	// pipeline to see if price is duplicate
@@ -511,6 +848,7 @@ func RemoveItem(itemName string, ChannelID string) int64 {
 	if err != nil {
 		slog.Error("couldnt remove from DB", slog.Any("Error", err))
 	}
+	removeSoldListings(soldItemFilter(itemName, ChannelID))
 	updateChannelLength(ChannelID, -1)
 	return results.DeletedCount
 }
@@ -521,7 +859,7 @@ func AddTrackingInfo(itemName string, uri string, querySelector string, ChannelI
 		slog.Error("couldnt load channel", slog.Any("Error", err))
 		return Item{}, Price{}, err
 	}
-	p, t, err := validateURI(uri, querySelector)
+	p, t, err := validateURI(uri, querySelector, ChannelID)
 	if err != nil {
 		return Item{}, *p, err
 	}
@@ -593,28 +931,72 @@ func InitDB(context context.Context) {
 	if err := Client.Ping(ctx, readpref.Primary()); err != nil {
 		panic(err)
 	}
+	migrateChannelTaxRate()
+	migrateChannelCurrency()
 	loadDBTables()
+	migrateMoneyFields()
 	slog.Info("DB Successfully Pinged")
 }
 
-func validateURI(uri string, querySelector string) (*Price, *TrackingInfo, error) {
+// new trackers have no shipping yet, so only the channel tax is added
+// before converting to the channel currency
+func validateURI(uri string, querySelector string, ChannelID string) (*Price, *TrackingInfo, error) {
 	_, err := url.ParseRequestURI(uri)
 	if err != nil {
 		slog.Error("Invalid url")
 		return &Price{}, &TrackingInfo{}, err
 	}
-	pr, err := crawler.GetPrice(uri, querySelector, true)
+	pr, err := crawler.CrawlPrice(ctx, uri, querySelector, true)
+	if err != nil {
+		return &Price{}, &TrackingInfo{}, err
+	}
+	currency := pr.Price.Currency
+	if currency == "" {
+		currency = crawler.CurrencyForURL(uri)
+	}
+	landed, err := landedPrice(pr.Price, types.Money{}, ChannelID)
 	if err != nil {
 		return &Price{}, &TrackingInfo{}, err
 	}
 	tracking := TrackingInfo{
-		URI:       uri,
-		HtmlQuery: querySelector,
+		URI:          uri,
+		HtmlQuery:    querySelector,
+		Strategy:     pr.Strategy,
+		Availability: pr.Availability,
+		Currency:     currency,
 	}
 	price := Price{
-		Date:  time.Now(),
-		Price: pr,
-		Url:   uri,
+		Date:         time.Now(),
+		Price:        landed,
+		RawPrice:     pr.Price,
+		Url:          uri,
+		Availability: pr.Availability,
 	}
 	return &price, &tracking, err
 }
+
+// trackers added before currencies were stored are guessed from the url
+func TrackerCurrency(Tracker *TrackingInfo) string {
+	if Tracker.Currency != "" {
+		return Tracker.Currency
+	}
+	return crawler.CurrencyForURL(Tracker.URI)
+}
+
+// adds the channel tax and the tracker shipping in the page currency, then
+// converts the total to the channel currency. a zero price stays zero
+func landedPrice(rawPrice types.Money, shipping types.Money, ChannelID string) (types.Money, error) {
+	currency := ChannelCurrency(ChannelID)
+	// the page can show its price in another currency than the shipping
+	if !shipping.IsZero() {
+		var err error
+		if shipping, err = crawler.ConvertMoney(shipping, rawPrice.Currency); err != nil {
+			return types.Money{}, err
+		}
+	}
+	landed := rawPrice.Landed(channelTaxRate(ChannelID), shipping)
+	if landed.IsZero() {
+		return types.Money{Currency: currency}, nil
+	}
+	return crawler.ConvertMoney(landed, currency)
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"log/slog"
+
+	types "priceTracker/Types"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// channels set up before the tax rate was configurable had the default
+// rate hard coded in the crawler
+func migrateChannelTaxRate() {
+	ChannelTable := Client.Database("tracker").Collection("ChannelIDs")
+	res, err := ChannelTable.UpdateMany(ctx,
+		bson.M{"TaxRate": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"TaxRate": DefaultTaxRate}})
+	if err != nil {
+		slog.Error("could not set default channel tax rate", slog.Any("Error", err))
+		return
+	}
+	if res.ModifiedCount != 0 {
+		slog.Info("set default channel tax rate", slog.Int64("Channels", res.ModifiedCount))
+	}
+}
+
+// channels from before multi currency support only tracked us prices
+func migrateChannelCurrency() {
+	ChannelTable := Client.Database("tracker").Collection("ChannelIDs")
+	res, err := ChannelTable.UpdateMany(ctx,
+		bson.M{"Currency": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"Currency": types.DefaultCurrency}})
+	if err != nil {
+		slog.Error("could not set default channel currency", slog.Any("Error", err))
+		return
+	}
+	if res.ModifiedCount != 0 {
+		slog.Info("set default channel currency", slog.Int64("Channels", res.ModifiedCount))
+	}
+}
+
+// items saved before prices were stored as types.Money have whole dollar
+// ints in every price field, this rewrites them as cents in the default
+// currency. only documents that still have a number price are touched so
+// it is safe to run on every start
+func migrateMoneyFields() {
+	legacy := bson.M{"$type": "number"}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"LowestPrice.Price": legacy},
+		bson.M{"CurrentLowestPrice.Price": legacy},
+		bson.M{"PriceHistory.Price": legacy},
+		bson.M{"EbayListings.Price": legacy},
+		bson.M{"ListingsHistory.Price": legacy},
+		bson.M{"SevenDayAggregate.AveragePrice": legacy},
+	}}
+	listingMoney := func(field string) bson.D {
+		return bson.D{{Key: "$map", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{field, bson.A{}}}}},
+			{Key: "as", Value: "l"},
+			{Key: "in", Value: bson.D{{Key: "$mergeObjects", Value: bson.A{
+				"$$l",
+				bson.D{
+					{Key: "Price", Value: legacyMoney("$$l.Price")},
+					{Key: "TotalPriceChange", Value: legacyMoney("$$l.TotalPriceChange")},
+				},
+			}}}},
+		}}}
+	}
+	update := mongo.Pipeline{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "LowestPrice.Price", Value: legacyMoney("$LowestPrice.Price")},
+			{Key: "CurrentLowestPrice.Price", Value: legacyMoney("$CurrentLowestPrice.Price")},
+			{Key: "PriceHistory", Value: bson.D{{Key: "$map", Value: bson.D{
+				{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$PriceHistory", bson.A{}}}}},
+				{Key: "as", Value: "p"},
+				{Key: "in", Value: bson.D{{Key: "$mergeObjects", Value: bson.A{
+					"$$p",
+					bson.D{{Key: "Price", Value: legacyMoney("$$p.Price")}},
+				}}}},
+			}}}},
+			{Key: "EbayListings", Value: listingMoney("$EbayListings")},
+			{Key: "ListingsHistory", Value: listingMoney("$ListingsHistory")},
+			{Key: "SevenDayAggregate.AveragePrice", Value: legacyMoney("$SevenDayAggregate.AveragePrice")},
+			{Key: "SevenDayAggregate.PriceSTDEV", Value: legacyMoney("$SevenDayAggregate.PriceSTDEV")},
+			{Key: "SevenDayAggregate.AveragePriceWhenSold", Value: legacyMoney("$SevenDayAggregate.AveragePriceWhenSold")},
+			{Key: "SevenDayAggregate.LowestPriceDuringTimePeriod", Value: legacyMoney("$SevenDayAggregate.LowestPriceDuringTimePeriod")},
+		}}},
+	}
+	for ChannelID, Table := range Tables {
+		res, err := Table.UpdateMany(ctx, filter, update)
+		if err != nil {
+			slog.Error("could not migrate prices to money",
+				slog.String("ChannelID", ChannelID), slog.Any("Error", err))
+			continue
+		}
+		if res.ModifiedCount != 0 {
+			slog.Info("migrated prices to money",
+				slog.String("ChannelID", ChannelID), slog.Int64("Items", res.ModifiedCount))
+		}
+	}
+}
+
+// converts a whole dollar number to a Money document and leaves anything
+// else, including already migrated prices, as it is
+func legacyMoney(field string) bson.D {
+	return bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$isNumber", Value: field}},
+		bson.D{
+			{Key: "Amount", Value: bson.D{{Key: "$toLong", Value: bson.D{{Key: "$multiply", Value: bson.A{field, 100}}}}}},
+			{Key: "Currency", Value: types.DefaultCurrency},
+		},
+		field,
+	}}}
+}
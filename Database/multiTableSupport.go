@@ -3,10 +3,13 @@ package database
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"log/slog"
+	"strings"
 
 	crawler "priceTracker/Crawler"
+	types "priceTracker/Types"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -20,8 +23,20 @@ type Channel struct {
 	Distance     int     `bson:"Distance"`
 	LocationCode string  `bson:"LocationCode"`
 	TotalItems   int     `bson:"TotalItems"`
+	// second hand sources enabled for the channel, empty means all
+	Sources []string `bson:"Sources"`
+	// sales tax as a fraction, 0.1 is 10%, applied to every crawled price
+	TaxRate float64 `bson:"TaxRate"`
+	// prices from every tracker are converted to this before comparing
+	Currency string `bson:"Currency"`
+	// local listings further than this many minutes of driving are
+	// skipped on top of the Distance check, 0 means no limit
+	MaxDriveTime int `bson:"MaxDriveTime"`
 }
 
+// tax rate of new channels, and of channels created before it was configurable
+const DefaultTaxRate = 0.1
+
 var (
 	// has the mongo table stored
 	Tables = make(map[string]*mongo.Collection)
@@ -51,6 +66,10 @@ func loadDBTables() {
 			Distance:     IDString.Distance,
 			LocationCode: IDString.LocationCode,
 			TotalItems:   IDString.TotalItems,
+			Sources:      IDString.Sources,
+			TaxRate:      IDString.TaxRate,
+			Currency:     IDString.Currency,
+			MaxDriveTime: IDString.MaxDriveTime,
 		}
 		if IDString.Lat == 0 || IDString.Long == 0 || IDString.Distance == 0 {
 			log.Panic("Could not load Channel, lat, long or distance empty")
@@ -63,7 +82,9 @@ func GetChannelInfo(ChannelID string) *Channel {
 	return ChannelMap[ChannelID]
 }
 
-func UpdateChannelOrCreateChannelItemTableIfMissing(ChannelID string, Location string, LocationCode string, maxDistance int) error {
+// sources can be nil, in which case an existing channel keeps its
+// current sources and a new channel uses all of them
+func UpdateChannelOrCreateChannelItemTableIfMissing(ChannelID string, Location string, LocationCode string, maxDistance int, sources []string) error {
 	Lat, Long, err := crawler.GetCoordinates(Location)
 	if err != nil {
 		return err
@@ -75,8 +96,20 @@ func UpdateChannelOrCreateChannelItemTableIfMissing(ChannelID string, Location s
 		Distance:     maxDistance,
 		LocationCode: LocationCode,
 		TotalItems:   0,
+		Sources:      sources,
+		TaxRate:      DefaultTaxRate,
+		Currency:     types.DefaultCurrency,
 	}
 	// if channelID already exists, just update the Coordinates in DB and memory
+	if old, ok := ChannelMap[ChannelID]; ok {
+		Channel.TotalItems = old.TotalItems
+		Channel.TaxRate = old.TaxRate
+		Channel.Currency = old.Currency
+		Channel.MaxDriveTime = old.MaxDriveTime
+		if sources == nil {
+			Channel.Sources = old.Sources
+		}
+	}
 	if _, ok := Tables[ChannelID]; ok {
 		ChannelMap[ChannelID] = &Channel
 		update := bson.M{
@@ -85,6 +118,7 @@ func UpdateChannelOrCreateChannelItemTableIfMissing(ChannelID string, Location s
 				"Lat":          Lat,
 				"Long":         Long,
 				"LocationCode": LocationCode,
+				"Sources":      Channel.Sources,
 			},
 		}
 		ChannelMap[ChannelID] = &Channel
@@ -127,12 +161,119 @@ func UpdateChannelOrCreateChannelItemTableIfMissing(ChannelID string, Location s
 	return err
 }
 
+// sets the second hand sources used by every item in the channel
+// that does not override them, nil enables all sources
+func EditChannelSources(ChannelID string, sources []string) error {
+	Channel, ok := ChannelMap[ChannelID]
+	if !ok {
+		return errors.New("channel not found in db, call setup function first")
+	}
+	ChannelTable := Client.Database("tracker").Collection("ChannelIDs")
+	res := ChannelTable.FindOneAndUpdate(ctx, bson.M{"ChannelID": ChannelID}, bson.M{
+		"$set": bson.M{
+			"Sources": sources,
+		},
+	})
+	if res.Err() != nil {
+		slog.Error("could not update channel sources", slog.Any("Error", res.Err()))
+		return res.Err()
+	}
+	Channel.Sources = sources
+	return nil
+}
+
+// rate is a fraction, 0.0825 for 8.25%
+func EditChannelTaxRate(ChannelID string, rate float64) error {
+	Channel, ok := ChannelMap[ChannelID]
+	if !ok {
+		return errors.New("channel not found in db, call setup function first")
+	}
+	if rate < 0 || rate >= 1 {
+		return errors.New("tax rate has to be between 0 and 100 percent")
+	}
+	ChannelTable := Client.Database("tracker").Collection("ChannelIDs")
+	res := ChannelTable.FindOneAndUpdate(ctx, bson.M{"ChannelID": ChannelID}, bson.M{
+		"$set": bson.M{
+			"TaxRate": rate,
+		},
+	})
+	if res.Err() != nil {
+		slog.Error("could not update channel tax rate", slog.Any("Error", res.Err()))
+		return res.Err()
+	}
+	Channel.TaxRate = rate
+	return nil
+}
+
+// minutes of driving, 0 turns the limit off
+func EditChannelMaxDriveTime(ChannelID string, minutes int) error {
+	Channel, ok := ChannelMap[ChannelID]
+	if !ok {
+		return errors.New("channel not found in db, call setup function first")
+	}
+	if minutes < 0 {
+		return errors.New("max drive time can't be negative")
+	}
+	ChannelTable := Client.Database("tracker").Collection("ChannelIDs")
+	res := ChannelTable.FindOneAndUpdate(ctx, bson.M{"ChannelID": ChannelID}, bson.M{
+		"$set": bson.M{
+			"MaxDriveTime": minutes,
+		},
+	})
+	if res.Err() != nil {
+		slog.Error("could not update channel max drive time", slog.Any("Error", res.Err()))
+		return res.Err()
+	}
+	Channel.MaxDriveTime = minutes
+	return nil
+}
+
+func channelTaxRate(ChannelID string) float64 {
+	if Channel, ok := ChannelMap[ChannelID]; ok {
+		return Channel.TaxRate
+	}
+	return DefaultTaxRate
+}
+
+// currency has to be in the exchange rate table. prices already stored
+// stay in the old currency and are converted when compared
+func EditChannelCurrency(ChannelID string, currency string) error {
+	Channel, ok := ChannelMap[ChannelID]
+	if !ok {
+		return errors.New("channel not found in db, call setup function first")
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if _, ok := crawler.GetExchangeRates().Rates[currency]; !ok {
+		return fmt.Errorf("no exchange rate for currency %s", currency)
+	}
+	ChannelTable := Client.Database("tracker").Collection("ChannelIDs")
+	res := ChannelTable.FindOneAndUpdate(ctx, bson.M{"ChannelID": ChannelID}, bson.M{
+		"$set": bson.M{
+			"Currency": currency,
+		},
+	})
+	if res.Err() != nil {
+		slog.Error("could not update channel currency", slog.Any("Error", res.Err()))
+		return res.Err()
+	}
+	Channel.Currency = currency
+	return nil
+}
+
+func ChannelCurrency(ChannelID string) string {
+	if Channel, ok := ChannelMap[ChannelID]; ok && Channel.Currency != "" {
+		return Channel.Currency
+	}
+	return types.DefaultCurrency
+}
+
 func ChannelDeleteHandler(ChannelID string) {
 	if _, ok := Tables[ChannelID]; ok {
 		ChannelTable := Client.Database("tracker").Collection("ChannelIDs")
 		ChannelTable.FindOneAndDelete(ctx, bson.M{"ChannelID": ChannelID})
 		delete(Tables, ChannelID)
 		delete(ChannelMap, ChannelID)
+		removeSoldListings(bson.M{"ChannelID": ChannelID})
 	}
 }
 
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"log/slog"
+	"time"
+
+	types "priceTracker/Types"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ebay sold listings of every channel, kept out of the item documents since
+// they pile up a lot faster than ListingsHistory
+func soldHistoryTable() *mongo.Collection {
+	return Client.Database("tracker").Collection("SoldHistory")
+}
+
+func soldItemFilter(Name string, ChannelID string) bson.M {
+	return bson.M{
+		"ChannelID": ChannelID,
+		"ItemName":  bson.M{"$regex": "^" + Name + "$", "$options": "i"},
+	}
+}
+
+// adds the channel tax and currency to the raw sold prices and upserts them
+// by url, then marks the item as crawled so it waits a day for the next one
+func AddSoldListings(Name string, sold []*types.SoldListing, ChannelID string) error {
+	Table, err := loadChannelTable(ChannelID)
+	if err != nil {
+		slog.Error("Could not load channel from db", slog.Any("Error", err))
+		return err
+	}
+	var models []mongo.WriteModel
+	for _, listing := range sold {
+		listing.Price, err = landedPrice(listing.RawPrice, listing.Shipping, ChannelID)
+		if err != nil {
+			slog.Error("could not convert sold price", slog.String("URL", listing.URL), slog.Any("Error", err))
+			continue
+		}
+		listing.ItemName = Name
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"ChannelID": ChannelID, "ItemName": Name, "URL": listing.URL}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"ChannelID": ChannelID,
+				"ItemName":  Name,
+				"Title":     listing.Title,
+				"URL":       listing.URL,
+				"Price":     listing.Price,
+				"RawPrice":  listing.RawPrice,
+				"Shipping":  listing.Shipping,
+				"SoldDate":  listing.SoldDate,
+				"Relevance": listing.Relevance,
+			}}).
+			SetUpsert(true))
+	}
+	if len(models) != 0 {
+		if _, err := soldHistoryTable().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			slog.Error("could not save sold listings", slog.String("Name", Name), slog.Any("Error", err))
+			return err
+		}
+	}
+	res := Table.FindOneAndUpdate(ctx, bson.M{"Name": Name}, bson.M{
+		"$set": bson.M{"LastSoldCrawl": time.Now()},
+	})
+	return res.Err()
+}
+
+// fills in the sold stats of the report from the sold history, the report
+// is left alone when nothing sold in the time period
+func addSoldStats(report *AggregateReport, Name string, endDate time.Time, Days int, ChannelID string) error {
+	filter := soldItemFilter(Name, ChannelID)
+	filter["SoldDate"] = bson.M{"$gte": endDate.AddDate(0, 0, -1*Days), "$lte": endDate}
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: filter}},
+		bson.D{
+			{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: nil},
+				{Key: "SoldListings", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "AverageSoldPrice", Value: bson.D{{Key: "$avg", Value: "$Price.Amount"}}},
+				{Key: "LowestSoldPrice", Value: bson.D{{Key: "$min", Value: "$Price.Amount"}}},
+				{Key: "HighestSoldPrice", Value: bson.D{{Key: "$max", Value: "$Price.Amount"}}},
+				{Key: "LastSoldDate", Value: bson.D{{Key: "$max", Value: "$SoldDate"}}},
+				{Key: "Currency", Value: bson.D{{Key: "$last", Value: "$Price.Currency"}}},
+			}},
+		},
+		bson.D{
+			{Key: "$project", Value: bson.D{
+				{Key: "SoldListings", Value: "$SoldListings"},
+				{Key: "AverageSoldPrice", Value: moneyExpr("$AverageSoldPrice")},
+				{Key: "LowestSoldPrice", Value: moneyExpr("$LowestSoldPrice")},
+				{Key: "HighestSoldPrice", Value: moneyExpr("$HighestSoldPrice")},
+				{Key: "LastSoldDate", Value: "$LastSoldDate"},
+			}},
+		},
+	}
+	cursor, err := soldHistoryTable().Aggregate(ctx, pipeline)
+	if err != nil {
+		slog.Error("couldnt aggregate sold history", slog.Any("Error", err))
+		return err
+	}
+	var res []*AggregateReport
+	if err = cursor.All(ctx, &res); err != nil {
+		slog.Error("couldnt aggregate sold history", slog.Any("Error", err))
+		return err
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	report.SoldListings = res[0].SoldListings
+	report.AverageSoldPrice = res[0].AverageSoldPrice
+	report.LowestSoldPrice = res[0].LowestSoldPrice
+	report.HighestSoldPrice = res[0].HighestSoldPrice
+	report.LastSoldDate = res[0].LastSoldDate
+	return nil
+}
+
+func renameSoldListings(oldName string, newName string, ChannelID string) error {
+	_, err := soldHistoryTable().UpdateMany(ctx, bson.M{"ChannelID": ChannelID, "ItemName": oldName},
+		bson.M{"$set": bson.M{"ItemName": newName}})
+	return err
+}
+
+func removeSoldListings(filter bson.M) {
+	if _, err := soldHistoryTable().DeleteMany(ctx, filter); err != nil {
+		slog.Error("could not remove sold listings", slog.Any("Filter", filter), slog.Any("Error", err))
+	}
+}
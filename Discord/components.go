@@ -0,0 +1,188 @@
+package discord
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	crawler "priceTracker/Crawler"
+	database "priceTracker/Database"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// message components put their handler name before the first | in the
+// custom ID, anything after it is handler specific
+var componentHandler = map[string]func(discord *discordgo.Session, i *discordgo.InteractionCreate){
+	"discover_selector":  selectorPickHandler,
+	"rejected_whitelist": rejectionHandler,
+	"rejected_relax":     rejectionHandler,
+}
+
+// state for selector pick lists that are waiting on the user, keyed by
+// the interaction ID of the /discover_selector command
+type selectorDiscovery struct {
+	Name      string
+	URI       string
+	Selectors []string
+}
+
+var (
+	pendingDiscoveries   = make(map[string]*selectorDiscovery)
+	pendingDiscoveriesMu sync.Mutex
+)
+
+// pick lists are dropped once the interaction window of the command is
+// over, whether or not anything was picked
+const discoveryExpiry = 15 * time.Minute
+
+func addPendingDiscovery(discoveryID string, discovery *selectorDiscovery) {
+	pendingDiscoveriesMu.Lock()
+	pendingDiscoveries[discoveryID] = discovery
+	pendingDiscoveriesMu.Unlock()
+	time.AfterFunc(discoveryExpiry, func() {
+		pendingDiscoveriesMu.Lock()
+		delete(pendingDiscoveries, discoveryID)
+		pendingDiscoveriesMu.Unlock()
+	})
+}
+
+func componentRouter(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	name, _, _ := strings.Cut(customID, "|")
+	if h, ok := componentHandler[name]; ok {
+		h(discord, i)
+	}
+}
+
+func selectorPickHandler(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
+	_, discoveryID, _ := strings.Cut(data.CustomID, "|")
+
+	pendingDiscoveriesMu.Lock()
+	discovery, ok := pendingDiscoveries[discoveryID]
+	pendingDiscoveriesMu.Unlock()
+	if !ok || len(data.Values) == 0 {
+		discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "Selector list expired, run /discover_selector again",
+			},
+		})
+		return
+	}
+	index, err := strconv.Atoi(data.Values[0])
+	if err != nil || index < 0 || index >= len(discovery.Selectors) {
+		slog.Error("invalid selector pick", slog.Any("Values", data.Values), slog.Any("Error", err))
+		// only the user who picked needs to see this, the list stays usable
+		discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "Invalid selector pick, choose one from the list again",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+	selector := discovery.Selectors[index]
+
+	// without an item name the selector is only shown so it can be used in /add
+	if discovery.Name == "" {
+		discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("Selected selector for %s: `%s`", discovery.URI, selector),
+			},
+		})
+		return
+	}
+
+	// adding a tracker crawls the page, so defer the response
+	discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	res, p, err := database.AddTrackingInfo(discovery.Name, discovery.URI, selector, i.ChannelID)
+	if err != nil {
+		discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+			Content: "Error adding tracker: " + err.Error(),
+		})
+		return
+	}
+	pendingDiscoveriesMu.Lock()
+	delete(pendingDiscoveries, discoveryID)
+	pendingDiscoveriesMu.Unlock()
+
+	em := setEmbed(&res)
+	em[len(em)-1].Fields = append(em[len(em)-1].Fields, setPriceField(&p, "Newly Added Tracker")...)
+	_, err = discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+		Embeds: em,
+	})
+	if err != nil {
+		slog.Error("failed to send discovered tracker embed", slog.Any("Error", err))
+	}
+}
+
+// discord caps custom IDs at 100 characters
+const maxCustomIDLen = 100
+
+// the item name is left out when it doesn't fit, the rejection ID is
+// unique per item so the lookup still finds the right one
+func rejectionCustomID(action string, itemName string, rejectionID string) string {
+	customID := action + "|" + itemName + "|" + rejectionID
+	if len(customID) > maxCustomIDLen {
+		return action + "|" + rejectionID
+	}
+	return customID
+}
+
+// whitelists the listing or relaxes the rule that rejected it, the custom
+// ID holds the item name and the rejection ID
+func rejectionHandler(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+	action, rest, _ := strings.Cut(i.MessageComponentData().CustomID, "|")
+	// item names can have a | in them, the rejection ID can't
+	itemName, rejectionID := "", rest
+	if n := strings.LastIndex(rest, "|"); n != -1 {
+		itemName, rejectionID = rest[:n], rest[n+1:]
+	}
+	respond := func(content string) {
+		discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Content: content},
+		})
+	}
+	item, rejection, err := database.GetRejection(itemName, rejectionID, i.ChannelID)
+	if err != nil {
+		respond("Rejection not found, it may have already been handled")
+		return
+	}
+	filter := item.Filter
+	content := ""
+	if action == "rejected_whitelist" {
+		if !slices.Contains(filter.AllowURLs, rejection.URL) {
+			filter.AllowURLs = append(slices.Clone(filter.AllowURLs), rejection.URL)
+		}
+		content = fmt.Sprintf("Whitelisted %s for %s, it skips the title and distance checks from the next crawl on",
+			rejection.URL, item.Name)
+	} else {
+		filter, err = crawler.RelaxTitleFilter(filter, item.Type, rejection)
+		if err != nil {
+			respond(err.Error())
+			return
+		}
+		content = fmt.Sprintf("Relaxed %s (%s) for %s, see /filters for the current rules",
+			rejection.Reason, rejection.Rule, item.Name)
+	}
+	if err := database.EditTitleFilter(item.Name, filter, i.ChannelID); err != nil {
+		slog.Error("could not update title filter", slog.String("Name", item.Name), slog.Any("Error", err))
+		respond("Error updating filters: " + err.Error())
+		return
+	}
+	if err := database.RemoveRejection(item.Name, rejection.ID, i.ChannelID); err != nil {
+		slog.Error("could not remove rejection", slog.String("ID", rejection.ID), slog.Any("Error", err))
+	}
+	respond(content)
+}
@@ -2,23 +2,31 @@ package discord
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	charts "priceTracker/Charts"
+	crawler "priceTracker/Crawler"
 	database "priceTracker/Database"
+	types "priceTracker/Types"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 var (
-	BotToken    string
-	Discord     *discordgo.Session
+	BotToken string
+	Discord  *discordgo.Session
+	// the ctx Run was started with, crawls started by commands use it so
+	// they stop on shutdown
+	botCtx      = context.Background()
 	commandList = []*discordgo.ApplicationCommand{
 		{
 			Name:        "setup",
@@ -42,12 +50,193 @@ var (
 					Type:        discordgo.ApplicationCommandOptionInteger,
 					Required:    true,
 				},
+				{
+					Name:        "sources",
+					Description: "comma separated second hand sources to crawl, defaults to all",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "tax_rate",
+					Description: "sales tax percent added to every price, defaults to 10",
+					Type:        discordgo.ApplicationCommandOptionNumber,
+					Required:    false,
+				},
+				{
+					Name:        "currency",
+					Description: "currency code prices are converted to, defaults to USD",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "max_drive_time",
+					Description: "skip local listings further than this many minutes of driving, 0 for no limit",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "settings",
+			Description: "Set the channel tax rate, currency or a flat shipping cost for a tracker",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "tax_rate",
+					Description: "sales tax percent added to every price",
+					Type:        discordgo.ApplicationCommandOptionNumber,
+					Required:    false,
+				},
+				{
+					Name:        "currency",
+					Description: "currency code prices are converted to, like USD or EUR",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:         "name",
+					Description:  "item of the tracker to set shipping for",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     false,
+					Autocomplete: true,
+				},
+				{
+					Name:         "tracker",
+					Description:  "tracker to set shipping for",
+					Type:         discordgo.ApplicationCommandOptionInteger,
+					Required:     false,
+					Autocomplete: true,
+				},
+				{
+					Name:        "shipping",
+					Description: "flat shipping cost in the tracker currency, 0 removes it",
+					Type:        discordgo.ApplicationCommandOptionNumber,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "edit_sources",
+			Description: "Set which second hand marketplaces are crawled for the channel or an item",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "sources",
+					Description: "comma separated sources, \"all\" crawls every source, \"default\" makes an item follow the channel",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:         "name",
+					Description:  "item to override, leave empty to edit the channel",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     false,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Name:        "filters",
+			Description: "Show or edit which second hand listing titles count as an item",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:         "name",
+					Description:  "item to show or edit the filters of",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Name:        "include",
+					Description: "comma separated keywords every title needs, \"none\" uses the item name",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "require_any",
+					Description: "groups split by ; of comma separated keywords, one per group is needed, \"none\" clears",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "exclude",
+					Description: "comma separated keywords that skip a title, \"none\" clears",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name:        "exclude_regex",
+					Description: "regexes split by ; that skip a title, \"none\" clears",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+				},
+				{
+					Name: "min_match",
+					Description: fmt.Sprintf("percent of the include keywords a title has to match, 0 goes back to the %d%% default",
+						int(crawler.DefaultTitleMinScore*100)),
+					Type:     discordgo.ApplicationCommandOptionNumber,
+					Required: false,
+					MinValue: new(float64),
+					MaxValue: 100,
+				},
+				{
+					Name:        "use_defaults",
+					Description: "also apply the default exclusions of the item type",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+				{
+					Name:        "reset",
+					Description: "drop every custom filter and go back to the defaults, whitelisted listings stay",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "rejected",
+			Description: "Show recently rejected second hand listings of an item and why",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:         "name",
+					Description:  "item to show the rejected listings of",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Name:        "auctions",
+			Description: "Track ebay auctions for the item and get alerted before they end",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:         "name",
+					Description:  "Add item name",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Name:        "enabled",
+					Description: "bool, wether to track auctions or not",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    true,
+				},
+				{
+					Name:        "alert_minutes",
+					Description: "how many minutes before an auction ends to alert, defaults to 30",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    false,
+				},
 			},
 		},
 		{
 			Name:        "channel_info",
 			Description: "get channel settings",
 		},
+		{
+			Name:        "crawler_status",
+			Description: "show proxy health and which sites are paused after repeated crawl failures",
+		},
 		{
 			Name:        "add",
 			Description: "Add new Price Tracker",
@@ -66,7 +255,7 @@ var (
 				},
 				{
 					Name:         "html_tag",
-					Description:  "Add Scrapping HTML Tag",
+					Description:  "Add Scrapping HTML Tag, or auto to read structured price data",
 					Type:         discordgo.ApplicationCommandOptionString,
 					Required:     true,
 					Autocomplete: true,
@@ -159,8 +348,8 @@ var (
 				},
 				{
 					Name:        "price",
-					Description: "desired price",
-					Type:        discordgo.ApplicationCommandOptionInteger,
+					Description: "desired price in the channel currency, cents are allowed",
+					Type:        discordgo.ApplicationCommandOptionNumber,
 					Required:    true,
 				},
 			},
@@ -225,7 +414,7 @@ var (
 						},
 						{
 							Name:         "html_tag",
-							Description:  "Add Scrapping HTML Tag",
+							Description:  "Add Scrapping HTML Tag, or auto to read structured price data",
 							Type:         discordgo.ApplicationCommandOptionString,
 							Required:     true,
 							Autocomplete: true,
@@ -255,6 +444,31 @@ var (
 				},
 			},
 		},
+		{
+			Name:        "discover_selector",
+			Description: "Find CSS selectors on a page that show the given price",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "uri",
+					Description: "page to search",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:        "price",
+					Description: "price shown on the page, in whole dollars",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Required:    true,
+				},
+				{
+					Name:         "name",
+					Description:  "item to add the picked selector to as a new tracker",
+					Type:         discordgo.ApplicationCommandOptionString,
+					Required:     false,
+					Autocomplete: true,
+				},
+			},
+		},
 		{
 			Name:        "graph",
 			Description: "graph price of item",
@@ -302,7 +516,7 @@ var (
 		},
 		{
 			Name:        "aggregate",
-			Description: "Get Aggregate Data for the Used Listings of the Item",
+			Description: "Get Aggregate Data for the Used Listings and eBay Sales of the Item",
 			Options: []*discordgo.ApplicationCommandOption{
 				{
 					Name:         "name",
@@ -340,11 +554,28 @@ var commandHandler = map[string]func(discord *discordgo.Session, i *discordgo.In
 		discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 		})
+		var sources []string
+		var err error
+		if opt := getOption(options, "sources"); opt != nil {
+			sources, err = crawler.ParseSourceList(opt.StringValue())
+		}
 		// add tracker to database
-		err := database.UpdateChannelOrCreateChannelItemTableIfMissing(i.ChannelID,
-			options[0].StringValue(),
-			options[1].StringValue(),
-			int(options[2].IntValue()))
+		if err == nil {
+			err = database.UpdateChannelOrCreateChannelItemTableIfMissing(i.ChannelID,
+				options[0].StringValue(),
+				options[1].StringValue(),
+				int(options[2].IntValue()),
+				sources)
+		}
+		if opt := getOption(options, "tax_rate"); opt != nil && err == nil {
+			err = database.EditChannelTaxRate(i.ChannelID, opt.FloatValue()/100)
+		}
+		if opt := getOption(options, "currency"); opt != nil && err == nil {
+			err = database.EditChannelCurrency(i.ChannelID, opt.StringValue())
+		}
+		if opt := getOption(options, "max_drive_time"); opt != nil && err == nil {
+			err = database.EditChannelMaxDriveTime(i.ChannelID, int(opt.IntValue()))
+		}
 		if err != nil {
 			content := err.Error()
 			discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
@@ -378,6 +609,212 @@ var commandHandler = map[string]func(discord *discordgo.Session, i *discordgo.In
 			})
 		}
 	},
+	"edit_sources": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+		options := i.ApplicationCommandData().Options
+		switch i.Type {
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			if opt := getOption(options, "name"); opt != nil {
+				autoComplete(opt.StringValue(), 0, i, discord)
+			}
+		default:
+			discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			list := options[0].StringValue()
+			var sources []string
+			var err error
+			if list != "all" && list != "default" {
+				sources, err = crawler.ParseSourceList(list)
+			}
+			content := ""
+			if err == nil {
+				if opt := getOption(options, "name"); opt != nil {
+					shown := formatSources(sources)
+					switch list {
+					case "all":
+						sources = []string{database.AllSources}
+					case "default":
+						shown = "Channel Default"
+					}
+					err = database.EditSources(opt.StringValue(), sources, i.ChannelID)
+					content = fmt.Sprintf("Second Hand Sources For %s: %s", opt.StringValue(), shown)
+				} else {
+					err = database.EditChannelSources(i.ChannelID, sources)
+					content = "Second Hand Sources For Channel: " + formatSources(sources)
+				}
+			}
+			if err != nil {
+				content = err.Error()
+			}
+			discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+				Content: content,
+			})
+		}
+	},
+	"settings": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+		options := i.ApplicationCommandData().Options
+		name := getOption(options, "name")
+		switch i.Type {
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			if tracker := getOption(options, "tracker"); tracker != nil && tracker.Focused && name != nil {
+				autoComplete(name.StringValue(), 1, i, discord)
+			} else if name != nil {
+				autoComplete(name.StringValue(), 0, i, discord)
+			}
+		default:
+			discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			var lines []string
+			var err error
+			if opt := getOption(options, "tax_rate"); opt != nil {
+				err = database.EditChannelTaxRate(i.ChannelID, opt.FloatValue()/100)
+				if err == nil {
+					lines = append(lines, fmt.Sprintf("Tax Rate: %g%%", opt.FloatValue()))
+				}
+			}
+			if opt := getOption(options, "currency"); opt != nil && err == nil {
+				err = database.EditChannelCurrency(i.ChannelID, opt.StringValue())
+				if err == nil {
+					lines = append(lines, "Currency: "+database.ChannelCurrency(i.ChannelID))
+				}
+			}
+			if opt := getOption(options, "shipping"); opt != nil && err == nil {
+				tracker := getOption(options, "tracker")
+				if name == nil || tracker == nil {
+					err = errors.New("name and tracker are required to set shipping")
+				} else {
+					var res database.Item
+					var shipping types.Money
+					res, err = database.GetItem(name.StringValue(), i.ChannelID)
+					if err == nil && (tracker.IntValue() < 0 || int(tracker.IntValue()) >= len(res.TrackingList)) {
+						err = errors.New("tracker not found for item")
+					}
+					if err == nil {
+						// shipping is charged in the same currency as the page
+						shipping = types.MoneyFromMajor(opt.FloatValue(),
+							database.TrackerCurrency(res.TrackingList[tracker.IntValue()]))
+						res, err = database.EditTrackerShipping(name.StringValue(), int(tracker.IntValue()), shipping, i.ChannelID)
+					}
+					if err == nil {
+						lines = append(lines, fmt.Sprintf("Shipping For %s: %s",
+							res.TrackingList[tracker.IntValue()].URI, shipping.String()))
+					}
+				}
+			}
+			content := strings.Join(lines, "\n")
+			if err != nil {
+				content = err.Error()
+			} else if len(lines) == 0 {
+				content = "Nothing to change, pass tax_rate, currency or name, tracker and shipping"
+			}
+			discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+				Content: content,
+			})
+		}
+	},
+	"filters": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+		options := i.ApplicationCommandData().Options
+		switch i.Type {
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			if opt := getOption(options, "name"); opt != nil {
+				autoComplete(opt.StringValue(), 0, i, discord)
+			}
+		default:
+			discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			name := getOption(options, "name").StringValue()
+			item, err := database.GetItem(name, i.ChannelID)
+			if err != nil {
+				discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+					Content: "Could not find item " + name,
+				})
+				return
+			}
+			filter := item.Filter
+			edited := len(options) > 1
+			if opt := getOption(options, "reset"); opt != nil && opt.BoolValue() {
+				// listings whitelisted through /rejected are not filters
+				filter = types.TitleFilter{AllowURLs: filter.AllowURLs}
+			}
+			if opt := getOption(options, "include"); opt != nil {
+				filter.Include = splitFilterList(opt.StringValue(), ",")
+			}
+			if opt := getOption(options, "require_any"); opt != nil {
+				filter.RequireAny = nil
+				for _, group := range splitFilterList(opt.StringValue(), ";") {
+					if words := splitFilterList(group, ","); len(words) != 0 {
+						filter.RequireAny = append(filter.RequireAny, words)
+					}
+				}
+			}
+			if opt := getOption(options, "exclude"); opt != nil {
+				filter.Exclude = splitFilterList(opt.StringValue(), ",")
+			}
+			if opt := getOption(options, "exclude_regex"); opt != nil {
+				filter.ExcludeRegexes = splitFilterList(opt.StringValue(), ";")
+			}
+			if opt := getOption(options, "min_match"); opt != nil {
+				filter.MinScore = opt.FloatValue() / 100
+			}
+			if opt := getOption(options, "use_defaults"); opt != nil {
+				filter.IgnoreDefaults = !opt.BoolValue()
+			}
+			if edited {
+				err = crawler.ValidateTitleFilter(filter)
+				if err == nil {
+					err = database.EditTitleFilter(item.Name, filter, i.ChannelID)
+				}
+				if err != nil {
+					discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+						Content: err.Error(),
+					})
+					return
+				}
+				item.Filter = filter
+			}
+			discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+				Embeds: []*discordgo.MessageEmbed{formatTitleFilter(&item)},
+			})
+		}
+	},
+	"rejected": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+		options := i.ApplicationCommandData().Options
+		switch i.Type {
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			if opt := getOption(options, "name"); opt != nil {
+				autoComplete(opt.StringValue(), 0, i, discord)
+			}
+		default:
+			name := getOption(options, "name").StringValue()
+			item, err := database.GetItem(name, i.ChannelID)
+			data := &discordgo.InteractionResponseData{}
+			if err != nil {
+				data.Content = "Could not find item " + name
+			} else {
+				data.Embeds, data.Components = formatRejections(&item)
+			}
+			err = discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: data,
+			})
+			if err != nil {
+				slog.Error("Error in Sending Rejected Listings", slog.Any("Error", err))
+			}
+		}
+	},
+	"crawler_status": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+		err := discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Embeds: []*discordgo.MessageEmbed{formatCrawlerStatus()},
+			},
+		})
+		if err != nil {
+			slog.Error("Error in Sending Crawler Status", slog.Any("Error", err))
+		}
+	},
 	"channel_info": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
 		info := database.GetChannelInfo(i.ChannelID)
 		em := formatChannelInfo(info)
@@ -388,7 +825,7 @@ var commandHandler = map[string]func(discord *discordgo.Session, i *discordgo.In
 			},
 		})
 		if err != nil {
-			slog.Error("Error in Sending ChannelInfo", err)
+			slog.Error("Error in Sending ChannelInfo", slog.Any("Error", err))
 		}
 	},
 	"add": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -479,6 +916,36 @@ var commandHandler = map[string]func(discord *discordgo.Session, i *discordgo.In
 			})
 		}
 	},
+	"auctions": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+		options := i.ApplicationCommandData().Options
+		switch i.Type {
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			autoComplete(options[0].StringValue(), 0, i, discord)
+		default:
+			discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			minutes := 0
+			if opt := getOption(options, "alert_minutes"); opt != nil {
+				minutes = int(opt.IntValue())
+			}
+			enabled := getOption(options, "enabled").BoolValue()
+			err := database.EditAuctionMode(options[0].StringValue(), enabled, minutes, i.ChannelID)
+			content := ""
+			if err != nil {
+				content = err.Error()
+			} else if !enabled {
+				content = fmt.Sprintf("Stopped tracking auctions for %s", options[0].StringValue())
+			} else {
+				item, _ := database.GetItem(options[0].StringValue(), i.ChannelID)
+				content = fmt.Sprintf("Tracking auctions for %s, alerting %d minutes before one under the price ends",
+					options[0].StringValue(), int(item.AuctionAlertBefore().Minutes()))
+			}
+			discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+				Content: content,
+			})
+		}
+	},
 	"get": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
 		// get command inputs from discord
 		options := i.ApplicationCommandData().Options
@@ -523,7 +990,8 @@ var commandHandler = map[string]func(discord *discordgo.Session, i *discordgo.In
 			if err != nil {
 				slog.Error("ack error", slog.Any("error value", err))
 			}
-			err = database.SetDesiredPrice(options[0].StringValue(), i.ChannelID, int(options[1].IntValue()))
+			err = database.SetDesiredPrice(options[0].StringValue(), i.ChannelID,
+				types.MoneyFromMajor(options[1].FloatValue(), database.ChannelCurrency(i.ChannelID)))
 			if err != nil {
 				content := err.Error()
 				discord.ChannelMessageSend(i.ChannelID, content)
@@ -696,6 +1164,59 @@ var commandHandler = map[string]func(discord *discordgo.Session, i *discordgo.In
 			}
 		}
 	},
+	"discover_selector": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
+		options := i.ApplicationCommandData().Options
+		switch i.Type {
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			if opt := getOption(options, "name"); opt != nil {
+				autoComplete(opt.StringValue(), 0, i, discord)
+			}
+		default:
+			discord.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			uri := options[0].StringValue()
+			candidates, err := crawler.DiscoverSelectors(botCtx, uri, int(options[1].IntValue()), 25)
+			if err != nil {
+				discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+					Content: "Could not discover selectors: " + err.Error(),
+				})
+				return
+			}
+			discovery := &selectorDiscovery{URI: uri}
+			if opt := getOption(options, "name"); opt != nil {
+				discovery.Name = opt.StringValue()
+			}
+			var menuOptions []discordgo.SelectMenuOption
+			for index, candidate := range candidates {
+				discovery.Selectors = append(discovery.Selectors, candidate.Selector)
+				menuOptions = append(menuOptions, discordgo.SelectMenuOption{
+					Label:       truncateString(candidate.Selector, 100),
+					Value:       strconv.Itoa(index),
+					Description: truncateString(fmt.Sprintf("matched %q, score %d", candidate.Text, candidate.Score), 100),
+				})
+			}
+			addPendingDiscovery(i.ID, discovery)
+
+			_, err = discord.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
+				Content: fmt.Sprintf("Found %d selectors for %s, most stable first", len(candidates), uri),
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{
+						Components: []discordgo.MessageComponent{
+							discordgo.SelectMenu{
+								CustomID:    "discover_selector|" + i.ID,
+								Placeholder: "Pick a selector",
+								Options:     menuOptions,
+							},
+						},
+					},
+				},
+			})
+			if err != nil {
+				slog.Error("failed to send selector pick list", slog.Any("Error", err))
+			}
+		}
+	},
 	"graph": func(discord *discordgo.Session, i *discordgo.InteractionCreate) {
 		options := i.ApplicationCommandData().Options
 
@@ -839,6 +1360,7 @@ func channelDeleteHandler(discord *discordgo.Session, i *discordgo.ChannelDelete
 }
 
 func Run(ctx context.Context) {
+	botCtx = ctx
 	// create a session
 	var err error
 	Discord, err = discordgo.New("Bot " + BotToken)
@@ -856,6 +1378,10 @@ func Run(ctx context.Context) {
 	Discord.Open()
 
 	Discord.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		if i.Type == discordgo.InteractionMessageComponent {
+			componentRouter(s, i)
+			return
+		}
 		if h, ok := commandHandler[i.ApplicationCommandData().Name]; ok {
 			h(s, i)
 		}
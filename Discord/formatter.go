@@ -3,7 +3,10 @@ package discord
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
+	crawler "priceTracker/Crawler"
 	database "priceTracker/Database"
 	types "priceTracker/Types"
 
@@ -99,9 +102,22 @@ func setTrackerFields(Item *database.Item) []*discordgo.MessageEmbedField {
 	fields = append(fields, &field)
 
 	for _, tracker := range Item.TrackingList {
+		query := tracker.HtmlQuery
+		if tracker.HtmlQuery == crawler.AutoSelector && tracker.Strategy != "" {
+			query += " (" + tracker.Strategy + ")"
+		}
+		if !tracker.Availability.Purchasable() {
+			query += " - " + tracker.Availability.Label()
+		}
+		if currency := database.TrackerCurrency(tracker); currency != types.DefaultCurrency {
+			query += " - Prices In " + currency
+		}
+		if !tracker.Shipping.IsZero() {
+			query += " - " + tracker.Shipping.String() + " Shipping"
+		}
 		field := discordgo.MessageEmbedField{
 			Name:   truncateString(tracker.URI, MaxFieldNameLen),
-			Value:  truncateString(tracker.HtmlQuery, MaxFieldValueLen),
+			Value:  truncateString(query, MaxFieldValueLen),
 			Inline: false,
 		}
 		separatorField := discordgo.MessageEmbedField{
@@ -120,14 +136,27 @@ func setSecondHandField(ebayArr []*types.EbayListing) []*discordgo.MessageEmbedF
 		return res
 	}
 
-	HeaderField := discordgo.MessageEmbedField{
-		Name: embedSeparatorFormatter("Ebay Listings", 44),
-	}
-	res = append(res, &HeaderField)
-
-	for _, Listing := range ebayArr {
-		listFields := formatSecondHandField(Listing, "Price", true)
-		res = append(res, listFields...)
+	// used and refurbished each get their own header, empty tiers are left out
+	for _, tier := range []types.ConditionTier{types.TierUsed, types.TierRefurbished} {
+		header := "Ebay Listings"
+		if tier == types.TierRefurbished {
+			header = "Refurbished Listings"
+		}
+		HeaderField := discordgo.MessageEmbedField{
+			Name: embedSeparatorFormatter(header, 44),
+		}
+		added := false
+		for _, Listing := range ebayArr {
+			if Listing.Tier != tier {
+				continue
+			}
+			if !added {
+				res = append(res, &HeaderField)
+				added = true
+			}
+			listFields := formatSecondHandField(Listing, "Price", true)
+			res = append(res, listFields...)
+		}
 	}
 	return res
 }
@@ -147,12 +176,28 @@ func formatSecondHandField(Listing *types.EbayListing, Message string, newListin
 	}
 	priceField := discordgo.MessageEmbedField{
 		Name:   truncateString(Listing.Title, MaxFieldNameLen),
-		Value:  "$" + strconv.Itoa(Listing.Price+1),
+		Value:  formatLandedPrice(Listing.Price, Listing.RawPrice),
 		Inline: false,
 	}
+	// listings saved before titles were scored have no relevance
+	if Listing.Relevance > 0 {
+		priceField.Value += fmt.Sprintf("\nTitle Match: %.0f%%", Listing.Relevance*100)
+	}
+	if Listing.Ships && !Listing.ShippingKnown {
+		priceField.Value += "\nShipping: not included, the listing doesn't show the cost"
+	}
+	// discord shows the end time relative to the reader
+	if Listing.IsAuction() {
+		priceField.Value += fmt.Sprintf("\nAuction: %d Bids, Ends <t:%d:R>", Listing.BidCount, Listing.EndTime.Unix())
+	}
+	// discord rejects embeds with an empty field
+	condition := Listing.Condition
+	if condition == "" {
+		condition = "Not listed"
+	}
 	conditionField := discordgo.MessageEmbedField{
 		Name:   "Condition/Location:",
-		Value:  truncateString(Listing.Condition, MaxFieldValueLen),
+		Value:  truncateString(condition, MaxFieldValueLen),
 		Inline: false,
 	}
 	urlField := discordgo.MessageEmbedField{
@@ -188,8 +233,8 @@ func formatSecondHandField(Listing *types.EbayListing, Message string, newListin
 			Inline: false,
 		}
 		totalPriceChange := discordgo.MessageEmbedField{
-			Name:   "Total Price Change $",
-			Value:  strconv.Itoa(Listing.TotalPriceChange),
+			Name:   "Total Price Change",
+			Value:  Listing.TotalPriceChange.String(),
 			Inline: false,
 		}
 		return append(ret, &currOrOld, &priceField, &AcceptsOffer, &conditionField, &urlField,
@@ -203,12 +248,17 @@ func formatSecondHandField(Listing *types.EbayListing, Message string, newListin
 func formatAggregateFields(Aggregate database.AggregateReport, message string) []*discordgo.MessageEmbedField {
 	/*
 		struct {
-		UniqueListings              int `bson:"UniqueListings"`
-		AverageDaysUP               int `bson:"AverageDaysUP"`
-		AveragePrice                int `bson:"AveragePrice"`
-		PriceSTDEV                  int `bson:"PriceSTDEV"`
-		AveragePriceWhenSold        int `bson:"AveragePriceWhenSold"`
-		LowestPriceDuringTimePeriod int `bson:"LowestPriceDuringTimePeriod"`
+		UniqueListings              int         `bson:"UniqueListings"`
+		AverageDaysUP               int         `bson:"AverageDaysUP"`
+		AveragePrice                types.Money `bson:"AveragePrice"`
+		PriceSTDEV                  types.Money `bson:"PriceSTDEV"`
+		AveragePriceWhenSold        types.Money `bson:"AveragePriceWhenSold"`
+		LowestPriceDuringTimePeriod types.Money `bson:"LowestPriceDuringTimePeriod"`
+		SoldListings                int         `bson:"SoldListings"`
+		AverageSoldPrice            types.Money `bson:"AverageSoldPrice"`
+		LowestSoldPrice             types.Money `bson:"LowestSoldPrice"`
+		HighestSoldPrice            types.Money `bson:"HighestSoldPrice"`
+		LastSoldDate                time.Time   `bson:"LastSoldDate"`
 	}*/
 	Message := discordgo.MessageEmbedField{
 		Name:   embedSeparatorFormatter(message, 43),
@@ -227,22 +277,35 @@ func formatAggregateFields(Aggregate database.AggregateReport, message string) [
 	}
 	AveragePrice := discordgo.MessageEmbedField{
 		Name:   "Avergae Price Of Listing:",
-		Value:  "$ " + strconv.Itoa(Aggregate.AveragePrice),
+		Value:  Aggregate.AveragePrice.String(),
 		Inline: false,
 	}
+	// actual ebay sales when there are any, otherwise the guess from
+	// listings that went down
 	AveragePriceWhenSold := discordgo.MessageEmbedField{
-		Name:   "Avergae Price Of Listing When Sold:",
-		Value:  "$ " + strconv.Itoa(Aggregate.AveragePriceWhenSold),
+		Name:   "Avergae Price Of Listing When Sold (Estimated):",
+		Value:  Aggregate.AveragePriceWhenSold.String(),
 		Inline: false,
 	}
+	if Aggregate.SoldListings > 0 {
+		AveragePriceWhenSold = discordgo.MessageEmbedField{
+			Name: fmt.Sprintf("Average eBay Sold Price (%d Sold):", Aggregate.SoldListings),
+			Value: fmt.Sprintf("%s\nRange: %s - %s\nLast Sold: %s",
+				Aggregate.AverageSoldPrice.String(),
+				Aggregate.LowestSoldPrice.String(),
+				Aggregate.HighestSoldPrice.String(),
+				Aggregate.LastSoldDate.Format("2006-01-02")),
+			Inline: false,
+		}
+	}
 	STDEV := discordgo.MessageEmbedField{
 		Name:   "STDEV of Prices:",
-		Value:  "$ " + strconv.Itoa(Aggregate.PriceSTDEV),
+		Value:  Aggregate.PriceSTDEV.String(),
 		Inline: false,
 	}
 	LowestPriceDuringTimePeriod := discordgo.MessageEmbedField{
 		Name:   "Lowest Price During Time Period:",
-		Value:  "$ " + strconv.Itoa(Aggregate.LowestPriceDuringTimePeriod),
+		Value:  Aggregate.LowestPriceDuringTimePeriod.String(),
 		Inline: false,
 	}
 	SeparatorField := discordgo.MessageEmbedField{
@@ -259,10 +322,10 @@ func setPriceField(p *database.Price, message string) []*discordgo.MessageEmbedF
 	priceField := discordgo.MessageEmbedField{
 		Name: embedSeparatorFormatter(fmt.Sprintf("%s Price", message), 44),
 		Value: func() string {
-			if p.Price == 0 {
+			if p.Price.IsZero() {
 				return "Item Unavailable"
 			} else {
-				return "$" + strconv.Itoa(p.Price+1)
+				return formatLandedPrice(p.Price, p.RawPrice)
 			}
 		}(),
 		Inline: false,
@@ -280,6 +343,13 @@ func setPriceField(p *database.Price, message string) []*discordgo.MessageEmbedF
 
 	var fields []*discordgo.MessageEmbedField
 	fields = append(fields, &priceField, &urlField, &dateField)
+	if p.Availability != crawler.AvailabilityUnknown {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   "Availability:",
+			Value:  p.Availability.Label(),
+			Inline: false,
+		})
+	}
 	return fields
 }
 
@@ -296,21 +366,187 @@ func formatChannelInfo(Channel *database.Channel) *discordgo.MessageEmbed {
 	}
 	distanceField := discordgo.MessageEmbedField{
 		Name:   "Max Distance",
-		Value:  strconv.Itoa(Channel.Distance),
+		Value:  strconv.Itoa(Channel.Distance) + " miles",
 		Inline: false,
 	}
+	driveTimeField := discordgo.MessageEmbedField{
+		Name:   "Max Drive Time",
+		Value:  "No Limit",
+		Inline: false,
+	}
+	if Channel.MaxDriveTime > 0 {
+		driveTimeField.Value = strconv.Itoa(Channel.MaxDriveTime) + " min"
+	}
 	totalItemField := discordgo.MessageEmbedField{
 		Name:   "Total Items",
 		Value:  strconv.Itoa(Channel.TotalItems),
 		Inline: false,
 	}
+	sourcesField := discordgo.MessageEmbedField{
+		Name:   "Second Hand Sources",
+		Value:  formatSources(Channel.Sources),
+		Inline: false,
+	}
+	taxField := discordgo.MessageEmbedField{
+		Name:   "Tax Rate",
+		Value:  fmt.Sprintf("%g%%", Channel.TaxRate*100),
+		Inline: false,
+	}
+	currencyField := discordgo.MessageEmbedField{
+		Name:   "Currency",
+		Value:  database.ChannelCurrency(Channel.ChannelID),
+		Inline: false,
+	}
 	em := &discordgo.MessageEmbed{
 		Title:  "Channel Information",
-		Fields: []*discordgo.MessageEmbedField{&ChannelIDField, &totalItemField, &locationField, &distanceField},
+		Fields: []*discordgo.MessageEmbedField{&ChannelIDField, &totalItemField, &locationField, &distanceField, &driveTimeField, &sourcesField, &taxField, &currencyField},
 	}
 	return em
 }
 
+// shows the price before tax and shipping next to the landed price, older
+// prices were saved without a raw price
+func formatLandedPrice(price types.Money, raw types.Money) string {
+	if raw.IsZero() || raw == price {
+		return price.String()
+	}
+	return fmt.Sprintf("%s (%s before tax and shipping)", price.String(), raw.String())
+}
+
+// empty source list means every registered source is crawled
+func formatSources(sources []string) string {
+	if len(sources) == 0 {
+		return "All (" + strings.Join(crawler.SecondHandSourceNames(), ", ") + ")"
+	}
+	return strings.Join(sources, ", ")
+}
+
+// rejections shown by /rejected, each gets a row of buttons and discord
+// allows 5 rows
+const maxShownRejections = 5
+
+func formatRejections(item *database.Item) ([]*discordgo.MessageEmbed, []discordgo.MessageComponent) {
+	em := &discordgo.MessageEmbed{
+		Title: "Rejected Listings For " + item.Name,
+		Color: 10181046, // purple
+	}
+	if len(item.Rejected) == 0 {
+		em.Description = "No listings were rejected in the recent crawls"
+		return []*discordgo.MessageEmbed{em}, nil
+	}
+	var rows []discordgo.MessageComponent
+	for n, r := range item.Rejected[:min(len(item.Rejected), maxShownRejections)] {
+		title := r.Title
+		if title == "" {
+			title = "Untitled Listing"
+		}
+		value := fmt.Sprintf("**%s**: %s\nPrice: %s\nSource: %s\nRejected: %s",
+			r.Reason, r.Rule, r.Price.String(), r.Source, r.Date.Format(time.DateTime))
+		if r.Relevance > 0 {
+			value += fmt.Sprintf("\nTitle Match: %.0f%%", r.Relevance*100)
+		}
+		value += "\n" + r.URL
+		em.Fields = append(em.Fields, &discordgo.MessageEmbedField{
+			Name:  truncateString(fmt.Sprintf("%d. %s", n+1, title), MaxFieldNameLen),
+			Value: truncateString(value, MaxFieldValueLen),
+		})
+		// whitelisting skips the title and distance checks, never the price
+		var buttons []discordgo.MessageComponent
+		if r.Reason != types.RejectPrice {
+			buttons = append(buttons, discordgo.Button{
+				Label:    fmt.Sprintf("Whitelist %d", n+1),
+				Style:    discordgo.SuccessButton,
+				CustomID: rejectionCustomID("rejected_whitelist", item.Name, r.ID),
+			})
+		}
+		if crawler.CanRelax(r) {
+			buttons = append(buttons, discordgo.Button{
+				Label:    fmt.Sprintf("Relax Rule %d", n+1),
+				Style:    discordgo.SecondaryButton,
+				CustomID: rejectionCustomID("rejected_relax", item.Name, r.ID),
+			})
+		}
+		if len(buttons) != 0 {
+			rows = append(rows, discordgo.ActionsRow{Components: buttons})
+		}
+	}
+	if len(item.Rejected) > maxShownRejections {
+		em.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%d older rejections not shown", len(item.Rejected)-maxShownRejections),
+		}
+	}
+	return []*discordgo.MessageEmbed{em}, rows
+}
+
+// splits a /filters list, "none" or an empty list clears the field
+func splitFilterList(list string, sep string) []string {
+	if strings.EqualFold(strings.TrimSpace(list), "none") {
+		return nil
+	}
+	var ret []string
+	for part := range strings.SplitSeq(list, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+func formatTitleFilter(item *database.Item) *discordgo.MessageEmbed {
+	f := item.Filter
+	include := "Every Word Of The Item Name"
+	if len(f.Include) != 0 {
+		include = strings.Join(f.Include, ", ")
+	}
+	var groups []string
+	for _, group := range f.RequireAny {
+		groups = append(groups, "("+strings.Join(group, " or ")+")")
+	}
+	requireAny := "None"
+	if len(groups) != 0 {
+		requireAny = strings.Join(groups, " and ")
+	}
+	exclude := "None"
+	if len(f.Exclude) != 0 {
+		exclude = strings.Join(f.Exclude, ", ")
+	}
+	excludeRegexes := "None"
+	if len(f.ExcludeRegexes) != 0 {
+		excludeRegexes = "`" + strings.Join(f.ExcludeRegexes, "`\n`") + "`"
+	}
+	minScore := "Default (" + strconv.Itoa(int(crawler.DefaultTitleMinScore*100)) + "%)"
+	if f.MinScore != 0 {
+		minScore = fmt.Sprintf("%g%%", f.MinScore*100)
+	}
+	defaults := "Off"
+	if !f.IgnoreDefaults {
+		defaults = "`" + strings.Join(crawler.DefaultTitleExcludes(item.Type), "` `") + "`"
+	}
+	itemType := item.Type
+	if itemType == "" {
+		itemType = "Untyped Items"
+	}
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Include", Value: truncateString(include, MaxFieldValueLen)},
+		{Name: "Minimum Match", Value: minScore},
+		{Name: "Require Any", Value: truncateString(requireAny, MaxFieldValueLen)},
+		{Name: "Exclude", Value: truncateString(exclude, MaxFieldValueLen)},
+		{Name: "Exclude Regexes", Value: truncateString(excludeRegexes, MaxFieldValueLen)},
+		{Name: "Default Exclusions For " + itemType, Value: truncateString(defaults, MaxFieldValueLen)},
+	}
+	if len(f.AllowURLs) != 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Whitelisted Listings",
+			Value: truncateString(strings.Join(f.AllowURLs, "\n"), MaxFieldValueLen),
+		})
+	}
+	return &discordgo.MessageEmbed{
+		Title:  "Title Filters For " + item.Name,
+		Color:  10181046, // purple
+		Fields: fields,
+	}
+}
+
 // Truncate string to max length with ellipsis
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
@@ -345,3 +581,57 @@ func embedSeparatorFormatter(s string, l int) string {
 	}
 	return s
 }
+
+func formatBreakerField(status crawler.BreakerStatus) []*discordgo.MessageEmbedField {
+	value := fmt.Sprintf("State: %s\nFailures In A Row: %d", status.State, status.Failures)
+	if status.State != crawler.BreakerClosed {
+		value += "\nRetrying At: " + status.OpenUntil.Format(time.DateTime)
+	}
+	if status.LastError != "" {
+		value += "\nLast Error: " + status.LastError
+	}
+	return []*discordgo.MessageEmbedField{{
+		Name:   truncateString(status.Domain, MaxFieldNameLen),
+		Value:  truncateString(value, MaxFieldValueLen),
+		Inline: false,
+	}}
+}
+
+// open breakers are listed first so healthy domains are the ones cut
+// off when there are more fields than an embed can hold
+func formatCrawlerStatus() *discordgo.MessageEmbed {
+	var fields []*discordgo.MessageEmbedField
+	for _, p := range crawler.ProxyStatuses() {
+		state := "Healthy"
+		if !p.Healthy {
+			state = "Out Of Rotation"
+		}
+		value := fmt.Sprintf("%s\nFailures In A Row: %d\nTotal: %d ok, %d failed",
+			state, p.Failures, p.TotalSuccesses, p.TotalFailures)
+		if !p.LastCheck.IsZero() {
+			value += "\nLast Health Check: " + p.LastCheck.Format(time.DateTime)
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   truncateString("Proxy "+p.URL, MaxFieldNameLen),
+			Value:  value,
+			Inline: false,
+		})
+	}
+	for _, b := range crawler.BreakerStatuses() {
+		fields = append(fields, formatBreakerField(b)...)
+	}
+	if len(fields) == 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "Nothing Crawled Yet",
+			Value: "no proxies configured and no domains crawled since the last restart",
+		})
+	}
+	if len(fields) > MaxFieldsPerEmbed {
+		fields = fields[:MaxFieldsPerEmbed]
+	}
+	return &discordgo.MessageEmbed{
+		Title:  "Crawler Status",
+		Color:  10181046, // purple
+		Fields: fields,
+	}
+}
@@ -1,13 +1,18 @@
 package discord
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"math"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	crawler "priceTracker/Crawler"
 	database "priceTracker/Database"
 	types "priceTracker/Types"
 
@@ -19,9 +24,10 @@ func ready(discord *discordgo.Session, ready *discordgo.Ready) {
 	discord.UpdateGameStatus(1, "stonks")
 }
 
-func PriceChangeAlert(itemName string, newPrice int, oldPrice database.Price, URL string, ChannelID string) {
+func PriceChangeAlert(itemName string, newPrice types.Money, oldPrice database.Price, URL string, ChannelID string) {
 	var color int
-	if newPrice > oldPrice.Price {
+	// the old low can be in an older channel currency
+	if higher, err := crawler.CompareMoney(newPrice, oldPrice.Price); err == nil && higher > 0 {
 		color = 16776960
 	} else {
 		color = 2067276
@@ -45,6 +51,43 @@ func PriceChangeAlert(itemName string, newPrice int, oldPrice database.Price, UR
 	Discord.ChannelMessageSendEmbed(ChannelID, &em)
 }
 
+func BackInStockAlert(itemName string, price types.Money, availability crawler.Availability, URL string, ChannelID string) {
+	priceField := setPriceField(&database.Price{
+		Price:        price,
+		Url:          URL,
+		Date:         time.Now(),
+		Availability: availability,
+	}, "Back In Stock")
+	em := discordgo.MessageEmbed{
+		Title:       "Back In Stock",
+		Description: itemName,
+		Color:       3447003, // blue
+		URL:         URL,
+		Fields:      priceField,
+	}
+	Discord.ChannelMessageSendEmbed(ChannelID, &em)
+}
+
+// sent once when a domain trips and once when it recovers, the crawl
+// errors in between are not alerted on
+func CircuitBreakerAlert(status crawler.BreakerStatus, ChannelID string) {
+	em := discordgo.MessageEmbed{
+		Title:       "Crawling Paused",
+		Description: status.Domain,
+		Color:       10038562, // red
+		Fields:      formatBreakerField(status),
+	}
+	if status.State == crawler.BreakerClosed {
+		em.Title = "Crawling Resumed"
+		em.Color = 3066993 // green
+	}
+	Discord.ChannelMessageSendEmbed(ChannelID, &em)
+}
+
+// total size of the artifacts attached to a crawl error alert, discord
+// allows 10MB per message without boosts
+const maxAlertUploadSize = 8 << 20
+
 func CrawlErrorAlert(itemName string, URL string, err error, ChannelID string) {
 	var s string
 	if err != nil {
@@ -52,6 +95,7 @@ func CrawlErrorAlert(itemName string, URL string, err error, ChannelID string) {
 			itemName, URL, err.Error())
 	} else {
 		s = fmt.Sprintf("returned price of 0 for item %s, with url %s", itemName, URL)
+		err = errors.New(s)
 	}
 	slog.Error(s)
 	nameField := discordgo.MessageEmbedField{
@@ -75,82 +119,66 @@ func CrawlErrorAlert(itemName string, URL string, err error, ChannelID string) {
 	}
 	var Fields []*discordgo.MessageEmbedField
 	Fields = append(Fields, &nameField, &urlField, &errField)
-	//
-	// <--------------- send screenshots of failed crawl --------->
-	if strings.Contains(err.Error(), "facebook") {
-		reader, err := os.Open("facebookSecond.png")
-		reader2, err2 := os.Open("facebookFirst.png")
-		reader3, err3 := os.Open("proxyFacebookSecond.png")
-		reader4, err4 := os.Open("proxyFacebookFirst.png")
-		reader5, err5 := os.Open("proxyFacebookHTML.html")
-		reader6, err6 := os.Open("facebookHTML.html")
 
-		defer reader.Close()
-		defer reader2.Close()
-		defer reader3.Close()
-		defer reader4.Close()
-		defer reader5.Close()
-		defer reader6.Close()
-		if err != nil || err2 != nil || err3 != nil ||
-			err4 != nil || err5 != nil || err6 != nil {
-			slog.Error("Could not load error images",
-				slog.Any("err", err),
-				slog.Any("err2", err2),
-				slog.Any("err3", err3),
-				slog.Any("err4", err4),
-				slog.Any("err5", err5),
-				slog.Any("err6", err6),
-			)
+	// <--------------- attach screenshots of this failed crawl --------->
+	paths := crawler.SaveArtifacts(itemName, err)
+	var files []*discordgo.File
+	var uploadSize int64
+	for _, path := range paths {
+		// discord only takes 10 files per message and drops the whole
+		// message when it is too big, the rest are still in the artifact
+		// folder
+		if len(files) == 10 {
+			break
 		}
-		Discord.ChannelFileSend(ChannelID, "second.png", reader)
-		Discord.ChannelFileSend(ChannelID, "first.png", reader2)
-		Discord.ChannelFileSend(ChannelID, "proxySecond.png", reader3)
-		Discord.ChannelFileSend(ChannelID, "proxyFirst.png", reader4)
-		Discord.ChannelFileSend(ChannelID, "proxyHTML.html", reader5)
-		Discord.ChannelFileSend(ChannelID, "facebook.html", reader6)
-	} else if strings.Contains(err.Error(), "Ebay") {
-		reader, err := os.Open("ebaySecond.png")
-		reader2, err2 := os.Open("ebayFirst.png")
-		defer reader.Close()
-		defer reader2.Close()
-		if err != nil || err2 != nil {
-			slog.Error("Could not load error images", slog.Any("Error", err),
-				slog.Any("Error", err2))
+		reader, err := os.Open(path)
+		if err != nil {
+			slog.Error("Could not load crawl artifact", slog.String("Path", path), slog.Any("Error", err))
+			continue
 		}
-		Discord.ChannelFileSend(ChannelID, "second.png", reader)
-		Discord.ChannelFileSend(ChannelID, "first.png", reader2)
-	} else {
-		reader, err := os.Open("failoverSS.png")
-		reader2, err2 := os.Open("failoverHTML.html")
-		reader3, err3 := os.Open("collyHTML.html")
-		reader4, err4 := os.Open("proxyFailoverSS.png")
-		reader5, err5 := os.Open("proxyFailoverHTML.html")
 		defer reader.Close()
-		defer reader2.Close()
-		defer reader3.Close()
-		defer reader4.Close()
-		defer reader5.Close()
-		if err != nil || err2 != nil || err3 != nil ||
-			err4 != nil || err5 != nil {
-			slog.Error("Could not send error image",
-				slog.Any("Error", err),
-				slog.Any("Error HTML File", err2),
-				slog.Any("err3", err3),
-				slog.Any("err4", err4),
-				slog.Any("err5", err5),
-			)
+		info, err := reader.Stat()
+		if err != nil || uploadSize+info.Size() > maxAlertUploadSize {
+			continue
 		}
-		Discord.ChannelFileSend(ChannelID, "collyHTML.html", reader3)
-		Discord.ChannelFileSend(ChannelID, "failoverSS.png", reader)
-		Discord.ChannelFileSend(ChannelID, "failoverHTML.html", reader2)
-		Discord.ChannelFileSend(ChannelID, "proxyFailoverSS.png", reader4)
-		Discord.ChannelFileSend(ChannelID, "proxyFailoverHTML.html", reader5)
+		uploadSize += info.Size()
+		files = append(files, &discordgo.File{
+			Name:   filepath.Base(filepath.Dir(filepath.Dir(path))) + "-" + filepath.Base(path),
+			Reader: reader,
+		})
+	}
+	var dirs []string
+	for _, path := range paths {
+		if dir := filepath.Dir(path); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) != 0 {
+		Fields = append(Fields, &discordgo.MessageEmbedField{
+			Name:   embedSeparatorFormatter("Debug Artifacts", 43),
+			Value:  truncateString(strings.Join(dirs, "\n"), MaxFieldValueLen),
+			Inline: false,
+		})
+	}
+	msg := &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:  "Error",
+			Fields: Fields,
+			Color:  10038562, // red
+		}},
+		Files: files,
+	}
+	_, err = Discord.ChannelMessageSendComplex(ChannelID, msg)
+	if err != nil && len(msg.Files) != 0 {
+		// the alert matters more than the screenshots, the folder is
+		// still listed in the embed
+		slog.Warn("Could not send crawl error alert with artifacts, sending without", slog.Any("Error", err))
+		msg.Files = nil
+		_, err = Discord.ChannelMessageSendComplex(ChannelID, msg)
+	}
+	if err != nil {
+		slog.Error("Could not send crawl error alert", slog.Any("Error", err))
 	}
-	Discord.ChannelMessageSendEmbed(ChannelID, &discordgo.MessageEmbed{
-		Title:  "Error",
-		Fields: Fields,
-		Color:  10038562, // red
-	})
 }
 
 func SendGraphPng(discord *discordgo.Session, ChannelID string) {
@@ -222,10 +250,14 @@ func autoComplete(Name string, t int, i *discordgo.InteractionCreate, discord *d
 func autoCompleteQuerySelector(i *discordgo.InteractionCreate, discord *discordgo.Session) {
 	items := database.AutoCompleteQuery()
 	var choices []*discordgo.ApplicationCommandOptionChoice
-	for name, query := range items {
+	// discord only accepts 25 choices, keep the order stable between calls
+	for _, name := range slices.Sorted(maps.Keys(items)) {
+		if len(choices) == 25 {
+			break
+		}
 		choice := discordgo.ApplicationCommandOptionChoice{
 			Name:  name,
-			Value: query,
+			Value: items[name],
 		}
 		choices = append(choices, &choice)
 	}
@@ -240,14 +272,17 @@ func autoCompleteQuerySelector(i *discordgo.InteractionCreate, discord *discordg
 	}
 }
 
-func EbayListingPriceChangeAlert(newListing *types.EbayListing, oldPrice int, ChannelID string) {
+func EbayListingPriceChangeAlert(newListing *types.EbayListing, oldListing *types.EbayListing, ChannelID string) {
 	colorCode := 1752220 // aqua
-	if oldPrice < newListing.Price {
+	if oldListing.Price.Cmp(newListing.Price) < 0 {
 		colorCode = 12745742 // dark gold
 	}
 	newFields := formatSecondHandField(newListing, "New Price", true)
-	newListing.Price = oldPrice
-	oldFields := formatSecondHandField(newListing, "Old Price", false)
+	// the new listing is saved by the caller, so the old prices go on a copy
+	old := *newListing
+	old.Price = oldListing.Price
+	old.RawPrice = oldListing.RawPrice
+	oldFields := formatSecondHandField(&old, "Old Price", false)
 	em := discordgo.MessageEmbed{
 		Title:  "Second Hand Listing Price Change For " + newListing.ItemName,
 		Color:  colorCode,
@@ -259,8 +294,12 @@ func EbayListingPriceChangeAlert(newListing *types.EbayListing, oldPrice int, Ch
 
 func NewEbayListingAlert(newListing *types.EbayListing, ChannelID string) {
 	fields := formatSecondHandField(newListing, "Price", true)
+	title := "New Second Hand Listing Found For "
+	if newListing.Tier == types.TierRefurbished {
+		title = "New Refurbished Listing Found For "
+	}
 	em := discordgo.MessageEmbed{
-		Title:  "New Second Hand Listing Found For " + newListing.ItemName,
+		Title:  title + newListing.ItemName,
 		Color:  15277667, // pink
 		URL:    newListing.URL,
 		Fields: fields,
@@ -268,6 +307,17 @@ func NewEbayListingAlert(newListing *types.EbayListing, ChannelID string) {
 	Discord.ChannelMessageSendEmbed(ChannelID, &em)
 }
 
+func AuctionEndingAlert(listing *types.EbayListing, ChannelID string) {
+	fields := formatSecondHandField(listing, "Current Bid", true)
+	em := discordgo.MessageEmbed{
+		Title:  "Auction Ending Soon For " + listing.ItemName,
+		Color:  15105570, // orange
+		URL:    listing.URL,
+		Fields: fields,
+	}
+	Discord.ChannelMessageSendEmbed(ChannelID, &em)
+}
+
 // for functions that will take too long(more than the 15 min resposne time
 // required)
 func customAcknowledge(discord *discordgo.Session, i *discordgo.InteractionCreate) error {
@@ -292,3 +342,14 @@ func customAcknowledge(discord *discordgo.Session, i *discordgo.InteractionCreat
 	})
 	return err
 }
+
+// finds an option by name, needed for optional options since discord
+// leaves them out of the array when they are not set
+func getOption(options []*discordgo.ApplicationCommandInteractionDataOption, Name string) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, option := range options {
+		if option.Name == Name {
+			return option
+		}
+	}
+	return nil
+}
@@ -2,9 +2,11 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"math"
 	"math/rand/v2"
+	"slices"
 	"time"
 
 	crawler "priceTracker/Crawler"
@@ -20,19 +22,29 @@ func SetChannelScheduler(ctx context.Context) {
 	// Check for new/deleted items every hour
 	refreshTicker := time.NewTicker(30 * time.Minute)
 	defer refreshTicker.Stop()
+	exchangeRateTicker := time.NewTicker(24 * time.Hour)
+	defer exchangeRateTicker.Stop()
+	// item crawls are hours apart, ending auctions are checked from the
+	// stored end times in between
+	auctionTicker := time.NewTicker(5 * time.Minute)
+	defer auctionTicker.Stop()
+	refreshExchangeRates()
+	crawler.OnBreakerChange = breakerChanged
 
 	activeRoutines := make(map[string]context.CancelFunc) // Track running goroutines
 	itemTimers := make(map[string]time.Duration)          // Track current timers
 	itemSuppression := make(map[string]bool)              // trakc noti suppression
 	itemTrackingList := make(map[string][]*database.TrackingInfo)
+	itemSources := make(map[string][]string) // track enabled second hand sources
+	itemAuctions := make(map[string]bool)    // track auction mode
 	for _, Channel := range database.ChannelMap {
 		itemsArr := database.GetAllItems(Channel.ChannelID)
 		for _, item := range itemsArr {
-			updateSingleItem(item, Channel)
+			updateSingleItem(ctx, item, Channel)
 		}
 	}
 	// Initial load for scheduler this runs after the timers hit tho not immediately
-	loadAndStartItems(ctx, activeRoutines, itemTimers, itemSuppression, itemTrackingList)
+	loadAndStartItems(ctx, activeRoutines, itemTimers, itemSuppression, itemTrackingList, itemSources, itemAuctions)
 
 	for {
 		select {
@@ -45,16 +57,59 @@ func SetChannelScheduler(ctx context.Context) {
 			return
 		case <-refreshTicker.C:
 			slog.Info("refreshing item list")
-			loadAndStartItems(ctx, activeRoutines, itemTimers, itemSuppression, itemTrackingList)
+			loadAndStartItems(ctx, activeRoutines, itemTimers, itemSuppression, itemTrackingList, itemSources, itemAuctions)
+		case <-exchangeRateTicker.C:
+			refreshExchangeRates()
+		case <-auctionTicker.C:
+			checkEndingAuctions()
 		}
 	}
 }
 
+// alerts every channel with an item crawled from the domain once, instead
+// of each item alerting on every tick while the breaker is open
+func breakerChanged(status crawler.BreakerStatus) {
+	for _, Channel := range database.ChannelMap {
+		for _, item := range database.GetAllItems(Channel.ChannelID) {
+			if itemUsesDomain(item, Channel, status.Domain) {
+				discord.CircuitBreakerAlert(status, Channel.ChannelID)
+				break
+			}
+		}
+	}
+}
+
+func itemUsesDomain(item *database.Item, Channel *database.Channel, domain string) bool {
+	for _, t := range item.TrackingList {
+		if crawler.BreakerDomain(t.URI) == domain {
+			return true
+		}
+	}
+	enabled := database.EnabledSources(item, Channel)
+	for _, name := range crawler.SecondHandSourceNames() {
+		source, _ := crawler.GetSecondHandSource(name)
+		if source.Domain() == domain && crawler.SupportsItemType(source, item.Type) &&
+			(len(enabled) == 0 || slices.Contains(enabled, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// the stored table keeps working when the refresh fails
+func refreshExchangeRates() {
+	if err := crawler.RefreshExchangeRates(); err != nil {
+		slog.Error("could not refresh exchange rates, using stored table", slog.Any("Error", err))
+	}
+}
+
 func loadAndStartItems(ctx context.Context,
 	activeRoutines map[string]context.CancelFunc,
 	itemTimers map[string]time.Duration,
 	itemSuppression map[string]bool,
 	itemTrackingList map[string][]*database.TrackingInfo,
+	itemSources map[string][]string,
+	itemAuctions map[string]bool,
 ) {
 	for _, Channel := range database.ChannelMap {
 		itemsArr := database.GetAllItems(Channel.ChannelID)
@@ -75,6 +130,8 @@ func loadAndStartItems(ctx context.Context,
 				oldSuppression, ok := itemSuppression[itemKey]
 				oldTimer, ok2 := itemTimers[itemKey]
 				oldTrackingList, ok3 := itemTrackingList[itemKey]
+				oldSources, ok4 := itemSources[itemKey]
+				oldAuctions, ok5 := itemAuctions[itemKey]
 				// check weather tracking list was changed
 				var wasTrackignListChanged bool
 				if ok3 && len(oldTrackingList) == len(item.TrackingList) {
@@ -91,6 +148,8 @@ func loadAndStartItems(ctx context.Context,
 
 				if (ok2 && oldTimer != newTimer) ||
 					(ok && oldSuppression != item.SuppressNotifications) ||
+					(ok4 && !slices.Equal(oldSources, database.EnabledSources(item, Channel))) ||
+					(ok5 && oldAuctions != item.AuctionMode) ||
 					wasTrackignListChanged {
 					slog.Info("timer changed or suppression changed for item, restarting",
 						slog.String("item", item.Name),
@@ -103,6 +162,8 @@ func loadAndStartItems(ctx context.Context,
 					delete(itemTimers, itemKey)
 					delete(itemSuppression, itemKey)
 					delete(itemTrackingList, itemKey)
+					delete(itemSources, itemKey)
+					delete(itemAuctions, itemKey)
 				} else {
 					slog.Info("suppression and timer unchanged skipping")
 					continue // Timer unchanged, skip
@@ -119,6 +180,8 @@ func loadAndStartItems(ctx context.Context,
 			itemTimers[itemKey] = newTimer
 			itemSuppression[itemKey] = item.SuppressNotifications
 			itemTrackingList[itemKey] = item.TrackingList
+			itemSources[itemKey] = database.EnabledSources(item, Channel)
+			itemAuctions[itemKey] = item.AuctionMode
 			slog.Info("Initializing Crawler Schedule",
 				slog.String("item", item.Name),
 				slog.String("timer", newTimer.String()))
@@ -129,6 +192,8 @@ func loadAndStartItems(ctx context.Context,
 				delete(itemTimers, itemKey)
 				delete(itemSuppression, itemKey)
 				delete(itemTrackingList, itemKey)
+				delete(itemSources, itemKey)
+				delete(itemAuctions, itemKey)
 			}(itemCtx, itemKey)
 		}
 	}
@@ -177,23 +242,34 @@ func itemCrawlRoutine(ctx context.Context, item *database.Item, Channel *databas
 			slog.Info("stopping item crawl routine", slog.String("item", item.Name))
 			return
 		case <-ticker.C:
-			updateSingleItem(item, Channel)
+			updateSingleItem(ctx, item, Channel)
 		}
 	}
 }
 
-func updateSingleItem(item *database.Item, Channel *database.Channel) {
+func updateSingleItem(ctx context.Context, item *database.Item, Channel *database.Channel) {
 	slog.Info("updating item",
 		slog.String("item", item.Name),
 		slog.String("channelID", Channel.ChannelID))
 
 	date := time.Now()
+	currency := Channel.Currency
+	if currency == "" {
+		currency = types.DefaultCurrency
+	}
 	// todays lowest price
 	currLow := database.Price{
-		Price: math.MaxInt,
+		Price: types.NewMoney(math.MaxInt64, currency),
 		Url:   "Unavailable From All Sources",
 		Date:  time.Now(),
 	}
+	// new prices are in the channel currency, the stored low might not be
+	// if the currency was changed since
+	if converted, err := crawler.ConvertMoney(item.CurrentLowestPrice.Price, currency); err == nil {
+		item.CurrentLowestPrice.Price = converted
+	} else {
+		slog.Error("could not convert lowest price", slog.String("item", item.Name), slog.Any("Error", err))
+	}
 
 	for _, t := range item.TrackingList {
 		// Random delay between sources (60-180 seconds)
@@ -203,31 +279,83 @@ func updateSingleItem(item *database.Item, Channel *database.Channel) {
 		// yesterdays lowest price
 		oldLow := item.CurrentLowestPrice
 
-		np, err := updatePrice(item.Name, t, oldLow, date, Channel.ChannelID, item.SuppressNotifications)
-		if currLow.Price > np.Price && err == nil {
+		np, err := updatePrice(ctx, item.Name, t, oldLow, date, Channel.ChannelID, item.SuppressNotifications)
+		// out of stock and third party only sources can't be bought
+		if err == nil && currLow.Price.Cmp(np.Price) > 0 && np.Availability.Purchasable() {
 			currLow = np
 		}
 	}
 
-	if currLow.Price == math.MaxInt {
+	if currLow.Price.Amount == math.MaxInt64 {
 		currLow.Price = item.CurrentLowestPrice.Price
 	}
 
 	item.CurrentLowestPrice = currLow
 	database.UpdateLowestPrice(item.Name, &currLow, Channel.ChannelID)
-	handleSecondHandListingsUpdate(item.Name, item.CurrentLowestPrice.Price, item.Type, Channel, item.SuppressNotifications, item.Timer)
+	// the crawl routine keeps the item it was started with, title filters
+	// edited through /filters or /rejected have to be picked up here
+	if fresh, err := database.GetItem(item.Name, Channel.ChannelID); err == nil {
+		item.Filter = fresh.Filter
+	}
+	handleSecondHandListingsUpdate(ctx, item.Name, item.CurrentLowestPrice.Price, item.Type,
+		database.EnabledSources(item, Channel), item.Filter, item.AuctionMode, Channel, item.SuppressNotifications, item.Timer)
+	updateSoldHistory(item, Channel)
 	database.UpdateAggregateReport(item.Name, Channel.ChannelID)
 }
 
-func updatePrice(Name string, Tracker *database.TrackingInfo, oldLow database.Price, date time.Time, ChannelID string, Suppress bool) (database.Price, error) {
-	newPrice, err := crawler.GetPrice(Tracker.URI, Tracker.HtmlQuery, true)
-	if err != nil || newPrice == 0 {
+// sold listings barely change between item updates, so they are crawled
+// at most once a day and only for items that search ebay
+func updateSoldHistory(item *database.Item, Channel *database.Channel) {
+	enabled := database.EnabledSources(item, Channel)
+	if len(enabled) != 0 && !slices.Contains(enabled, "ebay") {
+		return
+	}
+	if time.Since(item.LastSoldCrawl) < 24*time.Hour {
+		return
+	}
+	sold, err := crawler.GetEbaySoldListings(item.Name, item.Type, item.Filter)
+	if err != nil {
+		// the sold history is only used for stats, no need to alert
+		slog.Error("could not crawl ebay sold listings", slog.String("Name", item.Name), slog.Any("Error", err))
+		return
+	}
+	if err := database.AddSoldListings(item.Name, sold, Channel.ChannelID); err != nil {
+		slog.Error("could not save ebay sold listings", slog.String("Name", item.Name), slog.Any("Error", err))
+		return
+	}
+	// the crawl routine keeps the item it was started with
+	item.LastSoldCrawl = time.Now()
+}
+
+func updatePrice(ctx context.Context, Name string, Tracker *database.TrackingInfo, oldLow database.Price, date time.Time, ChannelID string, Suppress bool) (database.Price, error) {
+	res, err := crawler.CrawlPrice(ctx, Tracker.URI, Tracker.HtmlQuery, true)
+	if err != nil || (res.Price.IsZero() && res.Availability.Purchasable()) {
 		slog.Error("error getting price in updatePrice", slog.Any("Error", err),
-			slog.Int("Returned Price", newPrice))
-		discord.CrawlErrorAlert(Name, Tracker.URI, err, ChannelID)
+			slog.String("Returned Price", res.Price.String()))
+		// the channel was already told the whole domain is paused
+		if !errors.Is(err, crawler.ErrCircuitOpen) {
+			discord.CrawlErrorAlert(Name, Tracker.URI, err, ChannelID)
+		}
 		return database.Price{}, err
 	}
-	p, _ := database.AddNewPrice(Name, Tracker.URI, newPrice, date, ChannelID)
+	// compare landed prices since the old lowest price has tax and shipping in it
+	p, _ := database.AddNewPrice(Name, Tracker, res.Price, res.Availability, date, ChannelID)
+	newPrice := p.Price
+
+	oldAvailability := Tracker.Availability
+	if oldAvailability != res.Availability {
+		Tracker.Availability = res.Availability
+		database.UpdateTrackerAvailability(Name, Tracker.URI, res.Availability, ChannelID)
+		if !oldAvailability.Purchasable() && res.Availability.Confirmed() && !Suppress {
+			discord.BackInStockAlert(Name, newPrice, res.Availability, Tracker.URI, ChannelID)
+		}
+	}
+	if !res.Availability.Purchasable() {
+		slog.Info("tracker unavailable, excluding from lowest price",
+			slog.String("Name", Name), slog.String("URI", Tracker.URI),
+			slog.String("Availability", res.Availability.Label()))
+		return p, err
+	}
 
 	// notify discord if a new historical low has been achieved
 	if oldLow.Price != newPrice && !Suppress {
@@ -236,56 +364,138 @@ func updatePrice(Name string, Tracker *database.TrackingInfo, oldLow database.Pr
 	return p, err
 }
 
-func handleSecondHandListingsUpdate(Name string, Price int, Type string, Channel *database.Channel, Suppress bool, timer int) {
+func handleSecondHandListingsUpdate(ctx context.Context, Name string, Price types.Money, Type string, Sources []string, Filter types.TitleFilter, Auctions bool, Channel *database.Channel, Suppress bool, timer int) {
 	oldEbayListings, _ := database.GetEbayListings(Name, Channel.ChannelID)
 	ListingsMap := map[string]*types.EbayListing{} // maps titles to price for checking if price exists or was updated
 	for i := range oldEbayListings {
 		ListingsMap[oldEbayListings[i].URL] = oldEbayListings[i]
 	}
-	ebayListings, err := crawler.GetSecondHandListings(Name, Price,
-		Channel.Lat, Channel.Long, Channel.Distance, Type, Channel.LocationCode)
+	var rejected database.RejectionBuffer
+	crawled := map[string]bool{}
+	ebayListings, err := crawler.GetSecondHandListings(ctx, crawler.SecondHandQuery{
+		Name:         Name,
+		Price:        Price,
+		ItemType:     Type,
+		Lat:          Channel.Lat,
+		Long:         Channel.Long,
+		Distance:     Channel.Distance,
+		MaxDriveTime: Channel.MaxDriveTime,
+		LocationCode: Channel.LocationCode,
+		TaxRate:      Channel.TaxRate,
+		Filter:       Filter,
+		OnReject:     rejected.Add,
+		OnCrawled:    func(source string) { crawled[source] = true },
+		Auctions:     Auctions,
+	}, Sources)
+	if err := database.AddRejections(Name, rejected.Rejected, Channel.ChannelID); err != nil {
+		slog.Error("could not save rejected listings", slog.String("Name", Name), slog.Any("Error", err))
+	}
 	if err != nil {
 		discord.CrawlErrorAlert(Name, "Second Hand Listings", err, Channel.ChannelID)
-	} else {
-		for i := range ebayListings {
-			oldListing, ok := ListingsMap[ebayListings[i].URL]
-			// if listing not found in the old list, or if price changed
-			// ping discord
-			// update how long the listing has been online for
-			if ok {
-				if timer == 0 {
-					timer = 8
-				}
-				ebayListings[i].Duration = oldListing.Duration + time.Duration(timer)*time.Hour
-				if ebayListings[i].Price != oldListing.Price {
-					// update count for how many times price was increased
-					ebayListings[i].TotalPriceChange += ebayListings[i].Price - oldListing.Price
-					if ebayListings[i].Price > oldListing.Price {
-						ebayListings[i].PriceIncreaseNum = oldListing.PriceIncreaseNum + 1
-						ebayListings[i].PriceDecreaseNum = oldListing.PriceDecreaseNum
-					} else {
-						ebayListings[i].PriceDecreaseNum = oldListing.PriceDecreaseNum + 1
-						ebayListings[i].PriceIncreaseNum = oldListing.PriceIncreaseNum
-					}
-					if !Suppress &&
-						math.Abs(float64(oldListing.Price)-float64(ebayListings[i].Price)) > 5 {
-						discord.EbayListingPriceChangeAlert(ebayListings[i], oldListing.Price, Channel.ChannelID)
-					}
-				} else {
-					// have to pass down the stats since im not doing a look up eachtime
+	}
+	// the routine was stopped part way through the sources
+	if ctx.Err() != nil {
+		return
+	}
+	for i := range ebayListings {
+		oldListing, ok := ListingsMap[ebayListings[i].URL]
+		// if listing not found in the old list, or if price changed
+		// ping discord
+		// update how long the listing has been online for
+		if ok {
+			if timer == 0 {
+				timer = 8
+			}
+			ebayListings[i].Duration = oldListing.Duration + time.Duration(timer)*time.Hour
+			// the old price can be in an older channel currency, without a
+			// rate the change can't be worked out so it's left alone
+			old := *oldListing
+			if converted, err := crawler.ConvertMoney(old.Price, ebayListings[i].Price.Currency); err == nil {
+				old.Price = converted
+			} else {
+				slog.Error("could not convert old listing price", slog.String("URL", old.URL), slog.Any("Error", err))
+				old.Price = ebayListings[i].Price
+			}
+			oldListing = &old
+			if ebayListings[i].Price != oldListing.Price {
+				// update count for how many times price was increased
+				ebayListings[i].TotalPriceChange = ebayListings[i].Price.Sub(oldListing.Price).Add(ebayListings[i].TotalPriceChange)
+				if ebayListings[i].Price.Cmp(oldListing.Price) > 0 {
+					ebayListings[i].PriceIncreaseNum = oldListing.PriceIncreaseNum + 1
 					ebayListings[i].PriceDecreaseNum = oldListing.PriceDecreaseNum
+				} else {
+					ebayListings[i].PriceDecreaseNum = oldListing.PriceDecreaseNum + 1
 					ebayListings[i].PriceIncreaseNum = oldListing.PriceIncreaseNum
 				}
-			} else if !Suppress {
-				discord.NewEbayListingAlert(ebayListings[i], Channel.ChannelID)
+				// bids going up on an auction are not worth a ping
+				if !Suppress && !ebayListings[i].IsAuction() &&
+					math.Abs(oldListing.Price.Sub(ebayListings[i].Price).Major()) > 5 {
+					discord.EbayListingPriceChangeAlert(ebayListings[i], oldListing, Channel.ChannelID)
+				}
+			} else {
+				// have to pass down the stats since im not doing a look up eachtime
+				ebayListings[i].PriceDecreaseNum = oldListing.PriceDecreaseNum
+				ebayListings[i].PriceIncreaseNum = oldListing.PriceIncreaseNum
 			}
+		} else if !Suppress {
+			discord.NewEbayListingAlert(ebayListings[i], Channel.ChannelID)
 		}
-		err = database.UpdateEbayListings(Name, ebayListings, Channel.ChannelID)
-		if err != nil {
-			slog.Error("error updaing DB in ebay listing",
-				slog.Any("Error", err), slog.String("Name", Name))
-			discord.CrawlErrorAlert(Name, "www.ebay.com/DBError", err, Channel.ChannelID)
-			return
+	}
+	// sources that failed or were skipped by their breaker keep their old
+	// listings, so nothing is alerted as new once they work again
+	for _, old := range oldEbayListings {
+		if old.Source == "" || crawled[old.Source] || (len(Sources) != 0 && !slices.Contains(Sources, old.Source)) {
+			continue
+		}
+		if !slices.ContainsFunc(ebayListings, func(l *types.EbayListing) bool { return l.URL == old.URL }) {
+			ebayListings = append(ebayListings, old)
+		}
+	}
+	err = database.UpdateEbayListings(Name, ebayListings, Channel.ChannelID)
+	if err != nil {
+		slog.Error("error updaing DB in ebay listing",
+			slog.Any("Error", err), slog.String("Name", Name))
+		discord.CrawlErrorAlert(Name, "www.ebay.com/DBError", err, Channel.ChannelID)
+		return
+	}
+}
+
+// alerts on stored auctions that end within the alert time of their item
+// and are still under the price, each auction only alerts once
+func checkEndingAuctions() {
+	now := time.Now()
+	for _, Channel := range database.ChannelMap {
+		for _, item := range database.GetAllItems(Channel.ChannelID) {
+			if !item.AuctionMode || item.SuppressNotifications {
+				continue
+			}
+			listed := map[string]bool{}
+			for _, listing := range item.EbayListings {
+				listed[listing.URL] = true
+				if !listing.IsAuction() || slices.Contains(item.AlertedAuctions, listing.URL) || !listing.EndTime.After(now) ||
+					listing.EndTime.Sub(now) > item.AuctionAlertBefore() {
+					continue
+				}
+				// the stored price can be in an older channel currency
+				if limit, err := crawler.ConvertMoney(item.CurrentLowestPrice.Price, listing.Price.Currency); err == nil &&
+					listing.Price.Cmp(limit) >= 0 {
+					continue
+				}
+				discord.AuctionEndingAlert(listing, Channel.ChannelID)
+				if err := database.MarkAuctionAlerted(item.Name, listing.URL, Channel.ChannelID); err != nil {
+					slog.Error("could not mark auction alerted", slog.String("URL", listing.URL), slog.Any("Error", err))
+				}
+			}
+			// auctions that ended or sold drop out of the listings
+			var gone []string
+			for _, url := range item.AlertedAuctions {
+				if !listed[url] {
+					gone = append(gone, url)
+				}
+			}
+			if err := database.ForgetAlertedAuctions(item.Name, gone, Channel.ChannelID); err != nil {
+				slog.Error("could not forget alerted auctions", slog.String("Name", item.Name), slog.Any("Error", err))
+			}
 		}
 	}
 }
@@ -3,8 +3,16 @@ package types
 import "time"
 
 type EbayListing struct {
-	ItemName         string        `bson:"ItemName"`
-	Price            int           `bson:"Price"`
+	ItemName string `bson:"ItemName"`
+	Price    Money  `bson:"Price"`
+	// listing price before tax and shipping, Price is the landed price
+	RawPrice Money `bson:"RawPrice"`
+	Shipping Money `bson:"Shipping"`
+	// set by marketplaces where shipping is optional. ShippingKnown means
+	// the page showed the cost and it is in Shipping, a listing that ships
+	// without it has a landed price that leaves shipping out
+	Ships            bool          `bson:"Ships"`
+	ShippingKnown    bool          `bson:"ShippingKnown"`
 	URL              string        `bson:"URL"`
 	Duration         time.Duration `bson:"Duration"`
 	Title            string        `bson:"Title"`
@@ -12,6 +20,53 @@ type EbayListing struct {
 	Date             time.Time     `bson:"Date"`
 	PriceIncreaseNum int           `bson:"PriceIncreaseNum"`
 	PriceDecreaseNum int           `bson:"PriceDecreaseNum"`
-	TotalPriceChange int           `bson:"TotalPriceChange"`
+	TotalPriceChange Money         `bson:"TotalPriceChange"`
 	AcceptsOffers    bool          `bson:"AcceptsOffers"`
+	// how well the title matched the item from 0 to 1
+	Relevance float64 `bson:"Relevance"`
+	// auctions keep the current bid in Price
+	Type     ListingType `bson:"Type"`
+	BidCount int         `bson:"BidCount"`
+	// zero for buy it now listings
+	EndTime time.Time `bson:"EndTime"`
+	// certified refurbished listings are kept apart from the used ones
+	Tier ConditionTier `bson:"Tier"`
+	// name of the second hand source that found the listing, empty for
+	// listings saved before it was recorded
+	Source string `bson:"Source"`
+}
+
+type ConditionTier string
+
+const (
+	// every listing saved before tiers existed was used
+	TierUsed        ConditionTier = ""
+	TierRefurbished ConditionTier = "Refurbished"
+)
+
+type ListingType string
+
+const (
+	// listings saved before auctions were tracked have no type either
+	ListingBuyItNow ListingType = ""
+	ListingAuction  ListingType = "Auction"
+)
+
+func (l *EbayListing) IsAuction() bool {
+	return l.Type == ListingAuction
+}
+
+// completed ebay listing that actually sold, used for the market value in
+// the aggregate report
+type SoldListing struct {
+	ItemName string `bson:"ItemName"`
+	Title    string `bson:"Title"`
+	URL      string `bson:"URL"`
+	// landed price in the channel currency, same as EbayListing.Price
+	Price    Money     `bson:"Price"`
+	RawPrice Money     `bson:"RawPrice"`
+	Shipping Money     `bson:"Shipping"`
+	SoldDate time.Time `bson:"SoldDate"`
+	// how well the title matched the item from 0 to 1
+	Relevance float64 `bson:"Relevance"`
 }
@@ -0,0 +1,272 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// an amount in the minor units of its currency, cents for USD, so prices
+// keep their cents and are never compared across currencies by accident
+type Money struct {
+	Amount   int64  `bson:"Amount"`
+	Currency string `bson:"Currency"`
+}
+
+// currency used when the price text has no symbol or code in it
+const DefaultCurrency = "USD"
+
+// currencies without a minor unit, everything else has 2 decimals
+var zeroDecimalCurrencies = map[string]bool{
+	"JPY": true, "KRW": true, "VND": true, "CLP": true, "ISK": true,
+}
+
+// longer symbols first so C$ is not read as $
+var currencySymbols = []struct {
+	symbol   string
+	currency string
+}{
+	{"US$", "USD"}, {"CA$", "CAD"}, {"C$", "CAD"}, {"AU$", "AUD"}, {"A$", "AUD"},
+	{"NZ$", "NZD"}, {"HK$", "HKD"}, {"MX$", "MXN"}, {"R$", "BRL"},
+	{"€", "EUR"}, {"£", "GBP"}, {"¥", "JPY"}, {"₹", "INR"}, {"₩", "KRW"},
+	{"zł", "PLN"}, {"$", dollarSign},
+}
+
+// a bare $ is read as the default currency when that is a dollar, so
+// prices on a canadian store stay CAD
+const dollarSign = "$"
+
+var dollarCurrencies = map[string]bool{
+	"USD": true, "CAD": true, "AUD": true, "NZD": true, "HKD": true, "MXN": true,
+}
+
+// symbols used when formatting, other currencies are shown with their code
+var displaySymbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "INR": "₹", "KRW": "₩",
+	"CAD": "CA$", "AUD": "A$",
+}
+
+var (
+	isoCode = regexp.MustCompile(`\b(USD|CAD|AUD|NZD|HKD|MXN|BRL|EUR|GBP|JPY|INR|KRW|PLN|SEK|NOK|DKK|CHF|CNY)\b`)
+	// digits with any thousands or decimal separators between them
+	amountText = regexp.MustCompile(`\d[\d.,' \x{a0}\x{202f}]*`)
+	// a space separated thousands group, optionally with the decimals
+	thousandsGroup = regexp.MustCompile(`^\d{3}(?:[.,]\d+)?$`)
+)
+
+func NewMoney(amount int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{Amount: amount, Currency: currency}
+}
+
+// builds money from an amount in the major unit, like dollars entered in a
+// discord command, rounded to the nearest minor unit
+func MoneyFromMajor(amount float64, currency string) Money {
+	m := NewMoney(0, currency)
+	m.Amount = int64(math.Round(amount * float64(m.minorUnits())))
+	return m
+}
+
+func (m Money) minorUnits() int64 {
+	if zeroDecimalCurrencies[m.Currency] {
+		return 1
+	}
+	return 100
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// amount in the major unit, only meant for charts and other math that
+// does not need to be exact
+func (m Money) Major() float64 {
+	return float64(m.Amount) / float64(m.minorUnits())
+}
+
+// rounds to the nearest minor unit, used for tax and percentages
+func (m Money) Mul(factor float64) Money {
+	m.Amount = int64(math.Round(float64(m.Amount) * factor))
+	return m
+}
+
+// money in different currencies has to be converted before it can be
+// added or compared, doing it anyway is a bug so it panics instead of
+// giving a wrong total. zero is the same amount in every currency, so it
+// takes the currency of the other side
+func (m Money) sameCurrency(other Money) string {
+	a, b := m.Currency, other.Currency
+	if a == "" {
+		a = DefaultCurrency
+	}
+	if b == "" {
+		b = DefaultCurrency
+	}
+	switch {
+	case a == b || other.Amount == 0:
+		return m.Currency
+	case m.Amount == 0:
+		return other.Currency
+	}
+	panic(fmt.Sprintf("money currencies don't match: %s and %s", m, other))
+}
+
+func (m Money) Add(other Money) Money {
+	m.Currency = m.sameCurrency(other)
+	m.Amount += other.Amount
+	return m
+}
+
+// price actually paid, tax is only charged on the item itself and not on
+// shipping. a zero price means there is no price so nothing is added
+func (m Money) Landed(taxRate float64, shipping Money) Money {
+	if m.IsZero() {
+		return m
+	}
+	return m.Mul(1 + taxRate).Add(shipping)
+}
+
+func (m Money) Sub(other Money) Money {
+	m.Currency = m.sameCurrency(other)
+	m.Amount -= other.Amount
+	return m
+}
+
+// compares like cmp.Compare, money in another currency has to be
+// converted first
+func (m Money) Cmp(other Money) int {
+	m.sameCurrency(other)
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) String() string {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	units := m.minorUnits()
+	number := groupThousands(amount / units)
+	if units != 1 {
+		number += fmt.Sprintf(".%02d", amount%units)
+	}
+	if symbol, ok := displaySymbols[currency]; ok {
+		return sign + symbol + number
+	}
+	return sign + number + " " + currency
+}
+
+func groupThousands(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// parses price text in the common locale formats, "$1,299.99",
+// "1.299,00 €", "£1,299", "1 299,50 zł", using the currency symbol or ISO
+// code in the text and defaultCurrency when there is none
+func ParseMoney(priceStr string, defaultCurrency string) (Money, error) {
+	currency := detectCurrency(priceStr)
+	if currency == "" || (currency == dollarSign && dollarCurrencies[defaultCurrency]) {
+		currency = defaultCurrency
+	} else if currency == dollarSign {
+		currency = "USD"
+	}
+	m := NewMoney(0, currency)
+
+	priceStr = strings.NewReplacer("\n", "", "\r", "", "\t", "").Replace(priceStr)
+	number := joinSpaceGroups(amountText.FindString(priceStr))
+	number = strings.TrimRight(strings.ReplaceAll(number, "'", ""), ".,")
+	if number == "" {
+		return m, fmt.Errorf("no amount found in price %q", priceStr)
+	}
+
+	whole, fraction := splitDecimal(number, m.minorUnits() == 1)
+	whole = strings.NewReplacer(",", "", ".", "").Replace(whole)
+	if whole == "" {
+		whole = "0"
+	}
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return m, fmt.Errorf("could not parse price %q: %w", priceStr, err)
+	}
+	m.Amount = major * m.minorUnits()
+	if m.minorUnits() == 100 && fraction != "" {
+		// anything past the cents is dropped
+		fraction = (fraction + "0")[:2]
+		cents, err := strconv.ParseInt(fraction, 10, 64)
+		if err != nil {
+			return m, fmt.Errorf("could not parse price %q: %w", priceStr, err)
+		}
+		m.Amount += cents
+	}
+	return m, nil
+}
+
+// spaces only separate thousands when followed by exactly 3 digits, so
+// "1 299,50" is one amount but "12.99 2 for 1" stops at 12.99
+func joinSpaceGroups(match string) string {
+	groups := strings.Fields(match)
+	if len(groups) == 0 {
+		return ""
+	}
+	number := groups[0]
+	if len(number) > 3 || strings.ContainsAny(number, ".,'") {
+		return number
+	}
+	for _, group := range groups[1:] {
+		if !thousandsGroup.MatchString(group) || strings.ContainsAny(number, ".,") {
+			break
+		}
+		number += group
+	}
+	return number
+}
+
+// finds the decimal separator, the last separator wins when both are used,
+// a lone separator followed by exactly 3 digits is a thousands separator
+func splitDecimal(number string, noDecimals bool) (string, string) {
+	lastDot := strings.LastIndex(number, ".")
+	lastComma := strings.LastIndex(number, ",")
+	sep := max(lastDot, lastComma)
+	if sep == -1 || noDecimals {
+		return number, ""
+	}
+	if lastDot != -1 && lastComma != -1 {
+		return number[:sep], number[sep+1:]
+	}
+	sepChar := number[sep : sep+1]
+	if strings.Count(number, sepChar) > 1 || len(number)-sep-1 == 3 {
+		return number, ""
+	}
+	return number[:sep], number[sep+1:]
+}
+
+func detectCurrency(priceStr string) string {
+	if code := isoCode.FindString(strings.ToUpper(priceStr)); code != "" {
+		return code
+	}
+	for _, s := range currencySymbols {
+		if strings.Contains(priceStr, s.symbol) {
+			return s.currency
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package types
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	for _, tt := range []struct {
+		in              string
+		defaultCurrency string
+		want            Money
+	}{
+		{"$1,299.99", "USD", Money{129999, "USD"}},
+		{"1.299,00 €", "USD", Money{129900, "EUR"}},
+		{"£1,299", "USD", Money{129900, "GBP"}},
+		{"1 299,50 zł", "USD", Money{129950, "PLN"}},
+		{"EUR 19,99", "USD", Money{1999, "EUR"}},
+		{"Price: 1'299.00 CHF", "USD", Money{129900, "CHF"}},
+		{"CA$45.00", "USD", Money{4500, "CAD"}},
+		{"¥12,800", "USD", Money{12800, "JPY"}},
+		// a bare $ is the dollar of the store
+		{"$45", "CAD", Money{4500, "CAD"}},
+		{"$45", "EUR", Money{4500, "USD"}},
+		{"45.50", "GBP", Money{4550, "GBP"}},
+		{"+$12.00 delivery", "USD", Money{1200, "USD"}},
+		{"\n\t$549\n.99", "USD", Money{54999, "USD"}},
+		{"12.99 2 for 1", "USD", Money{1299, "USD"}},
+		{"1,5", "EUR", Money{150, "EUR"}},
+		// one separator followed by 3 digits groups thousands
+		{"$12.999", "USD", Money{1299900, "USD"}},
+		{"$9.999", "USD", Money{999900, "USD"}},
+	} {
+		got, err := ParseMoney(tt.in, tt.defaultCurrency)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q, %s) = %v, %v, want %v", tt.in, tt.defaultCurrency, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyNoAmount(t *testing.T) {
+	for _, in := range []string{"", "Free", "See price in cart", "$"} {
+		if got, err := ParseMoney(in, DefaultCurrency); err == nil {
+			t.Errorf("ParseMoney(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	for _, tt := range []struct {
+		in   Money
+		want string
+	}{
+		{Money{129999, "USD"}, "$1,299.99"},
+		{Money{-1550, "USD"}, "-$15.50"},
+		{Money{5, ""}, "$0.05"},
+		{Money{129900, "EUR"}, "€1,299.00"},
+		{Money{12800, "JPY"}, "¥12,800"},
+		{Money{129900, "CHF"}, "1,299.00 CHF"},
+		{Money{4500, "CAD"}, "CA$45.00"},
+	} {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMath(t *testing.T) {
+	price := NewMoney(10000, "")
+	if got := price.Landed(0.0925, NewMoney(1500, "USD")); got != (Money{12425, "USD"}) {
+		t.Errorf("got landed price %v", got)
+	}
+	// no price means nothing to add tax or shipping to
+	if got := (Money{Currency: "USD"}).Landed(0.0925, NewMoney(1500, "USD")); !got.IsZero() {
+		t.Errorf("got landed price %v for a missing price", got)
+	}
+	if got := MoneyFromMajor(19.999, "USD"); got != (Money{2000, "USD"}) {
+		t.Errorf("got %v", got)
+	}
+	if got := MoneyFromMajor(1299.6, "JPY"); got != (Money{1300, "JPY"}) {
+		t.Errorf("got %v", got)
+	}
+	if got := NewMoney(4999, "USD").Sub(NewMoney(5000, "USD")); got.Cmp(Money{}) >= 0 {
+		t.Errorf("got %v, want a negative amount", got)
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	// zero is zero in any currency, so it takes the other currency
+	if got := (Money{}).Add(NewMoney(500, "EUR")); got != (Money{500, "EUR"}) {
+		t.Errorf("got %v", got)
+	}
+	if got := NewMoney(500, "EUR").Sub(Money{Currency: "USD"}); got != (Money{500, "EUR"}) {
+		t.Errorf("got %v", got)
+	}
+	if got := (Money{100, ""}).Cmp(NewMoney(100, "USD")); got != 0 {
+		t.Errorf("got %d, no currency is the default currency", got)
+	}
+
+	for name, f := range map[string]func(){
+		"add": func() { NewMoney(500, "USD").Add(NewMoney(500, "EUR")) },
+		"sub": func() { NewMoney(500, "USD").Sub(NewMoney(500, "CAD")) },
+		"cmp": func() { NewMoney(500, "GBP").Cmp(NewMoney(400, "USD")) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("mixing currencies did not panic")
+				}
+			}()
+			f()
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+type RejectReason string
+
+const (
+	RejectTitleMatch RejectReason = "title match too low"
+	RejectRequireAny RejectReason = "missing required keyword"
+	RejectExcluded   RejectReason = "excluded keyword"
+	RejectPrice      RejectReason = "over price limit"
+	RejectDistance   RejectReason = "too far"
+)
+
+// second hand listing a crawl dropped and why, kept so filters can be
+// tuned without reading the logs
+type RejectedListing struct {
+	// hash of the item name and url, a listing is only kept once per item
+	// with its latest reason
+	ID     string       `bson:"ID"`
+	Source string       `bson:"Source"`
+	Title  string       `bson:"Title"`
+	URL    string       `bson:"URL"`
+	Price  Money        `bson:"Price"`
+	Reason RejectReason `bson:"Reason"`
+	// what rejected it, the exclude pattern, the required keyword group,
+	// the price limit or the distance
+	Rule      string    `bson:"Rule"`
+	Relevance float64   `bson:"Relevance"`
+	Date      time.Time `bson:"Date"`
+}
@@ -0,0 +1,23 @@
+package types
+
+// per item rules for which second hand listing titles are relevant,
+// the zero value behaves like the old global check
+type TitleFilter struct {
+	// every keyword has to be in the title, empty means every word of the
+	// item name
+	Include []string `bson:"Include"`
+	// each group needs at least one of its keywords in the title, for
+	// things like an item that is fine in either "ti" or "super"
+	RequireAny [][]string `bson:"RequireAny"`
+	// titles with any of these words are skipped
+	Exclude []string `bson:"Exclude"`
+	// regexes checked on top of Exclude, same syntax as the defaults
+	ExcludeRegexes []string `bson:"ExcludeRegexes"`
+	// drops the default exclusions of the item type
+	IgnoreDefaults bool `bson:"IgnoreDefaults"`
+	// share of the include keywords a title has to match from 0 to 1,
+	// 0 uses the default
+	MinScore float64 `bson:"MinScore"`
+	// listing urls that skip the title and distance checks
+	AllowURLs []string `bson:"AllowURLs"`
+}
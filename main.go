@@ -12,6 +12,7 @@ import (
 	discord "priceTracker/Discord"
 	logger "priceTracker/Logger"
 	scheduler "priceTracker/Scheduler"
+	types "priceTracker/Types"
 
 	"github.com/joho/godotenv"
 )
@@ -24,6 +25,7 @@ func main() {
 	discord.BotToken = os.Getenv("PUBLIC_KEY")
 	ctx, cancel := context.WithCancel(context.Background())
 	database.InitDB(ctx)
+	go crawler.StartProxyHealthChecks(ctx)
 	go scheduler.SetChannelScheduler(ctx)
 	var wg sync.WaitGroup
 	wg.Go(func() {
@@ -39,25 +41,27 @@ func main() {
 	slog.Info("Shutdown")
 	cancel()
 	wg.Wait()
+	crawler.CloseBrowsers()
 }
 
 func amazonTest() {
-	i, err := crawler.GetPrice("https://www.amazon.com/dp/B0B3F8V4JG?ref=cm_sw_r_ud_dp_EX1QNBD4J564MEHGZ4Y1&ref_=cm_sw_r_ud_dp_EX1QNBD4J564MEHGZ4Y1&social_share=cm_sw_r_ud_dp_EX1QNBD4J564MEHGZ4Y1&language=en-US",
+	i, err := crawler.GetPrice(context.Background(), "https://www.amazon.com/dp/B0B3F8V4JG?ref=cm_sw_r_ud_dp_EX1QNBD4J564MEHGZ4Y1&ref_=cm_sw_r_ud_dp_EX1QNBD4J564MEHGZ4Y1&social_share=cm_sw_r_ud_dp_EX1QNBD4J564MEHGZ4Y1&language=en-US",
 		"form#addToCart span.a-price-whole", true)
-	slog.Info("price", slog.Int("int", i), slog.Any("error", err))
+	slog.Info("price", slog.String("price", i.String()), slog.Any("error", err))
 }
 
 func BestBuyTest() {
-	i, err := crawler.GetPrice("https://www.bestbuy.com/product/msi-mpg-322urx-qd-oled-32-quantum-dot-oled-uhd-240hz-0-03ms-gaming-monitor-with-hdr400-displayport-2-1a-hdmi-usb-black/J3P7TX99VT/sku/6614908?sb_share_source=PDP&ref=app_pdp&loc=pdp_page",
+	i, err := crawler.GetPrice(context.Background(), "https://www.bestbuy.com/product/msi-mpg-322urx-qd-oled-32-quantum-dot-oled-uhd-240hz-0-03ms-gaming-monitor-with-hdr400-displayport-2-1a-hdmi-usb-black/J3P7TX99VT/sku/6614908?sb_share_source=PDP&ref=app_pdp&loc=pdp_page",
 		"div[data-testid='price-block-customer-price']", true)
-	slog.Info("price", slog.Int("int", i), slog.Any("error", err))
+	slog.Info("price", slog.String("price", i.String()), slog.Any("error", err))
 }
 
 func crawlerTest() {
-	crawler.GetPrice("https://www.bhphotovideo.com/c/product/1752177-REG/fractal_design_fd_c_nor1c_02_north_mid_tower_atx_case.html",
+	crawler.GetPrice(context.Background(), "https://www.bhphotovideo.com/c/product/1752177-REG/fractal_design_fd_c_nor1c_02_north_mid_tower_atx_case.html",
 		"span[class^='price_']", true)
-	crawler.GetPrice("https://www.newegg.com/fractal-design-atx-mid-tower-meshify-3-steel-pc-case-white-fd-c-mes3a-04/p/N82E16811352227",
+	crawler.GetPrice(context.Background(), "https://www.newegg.com/fractal-design-atx-mid-tower-meshify-3-steel-pc-case-white-fd-c-mes3a-04/p/N82E16811352227",
 		"li.price-current strong", true)
-	itemArr, err := crawler.EbayFailover("https://www.ebay.com/sch/i.html?_nkw=rtx%203060%20ti&LH_ItemCondition=3000|2020|2010|1500&_udhi=707&rt=nc&LH_BIN=1&_stpos=90274&_fcid=1", 1000, "rtx 3060 ti")
+	itemArr, err := crawler.EbayFailover(context.Background(), "https://www.ebay.com/sch/i.html?_nkw=rtx%203060%20ti&LH_ItemCondition=3000|2020|2010|1500&_udhi=707&rt=nc&LH_BIN=1&_stpos=90274&_fcid=1", types.MoneyFromMajor(1000, types.DefaultCurrency), "rtx 3060 ti",
+		crawler.NewTitleRules("rtx 3060 ti", "Tech", types.TitleFilter{}))
 	slog.Info("ebay test", slog.Any("itemArr", itemArr), slog.Any("err", err))
 }